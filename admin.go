@@ -0,0 +1,80 @@
+package bitlox
+
+import (
+	"bitlox/hid"
+	models "bitlox/proto"
+
+	"context"
+	"errors"
+	"fmt"
+)
+
+// the size of the host entropy pool sent when formatting
+const FORMAT_ENTROPY_LENGTH = 32
+
+var ERR_ENTROPY_TOO_SHORT = errors.New(fmt.Sprintf("Entropy pool must be at least %d bytes", FORMAT_ENTROPY_LENGTH))
+
+// ChangeDeviceName sets the device name reported in Features. The
+// device asks for a button press first.
+func ChangeDeviceName(ctx context.Context, d *Device, name string) error {
+	nameBytes, err := encodeName(name)
+	if err != nil {
+		return err
+	}
+
+	err = callSuccess(ctx, d, hid.PREFIX_CHANGE_DEVICE_NAME, &models.ChangeDeviceName{DeviceName: nameBytes})
+	if err == nil && d.features != nil {
+		d.features.DeviceName = nameBytes
+	}
+	return err
+}
+
+// ResetLang brings up the language menu on the device and returns once
+// a language is picked
+func ResetLang(ctx context.Context, d *Device) error {
+	return callSuccess(ctx, d, hid.COMMAND_RESET_LANG, nil)
+}
+
+// ResetPIN starts a PIN change on the device. The current and new PIN
+// are entered on the device, which must have a wallet loaded.
+func ResetPIN(ctx context.Context, d *Device) error {
+	if d.wallet < 0 {
+		return ERR_NO_WALLET_LOADED
+	}
+	return callSuccess(ctx, d, hid.COMMAND_RESET_PIN, nil)
+}
+
+// FormatWalletArea erases every wallet on the device. pool is host
+// entropy mixed into the device's random number generator, it must be
+// at least FORMAT_ENTROPY_LENGTH bytes from a good source such as
+// crypto/rand. The device asks for a button press first.
+func FormatWalletArea(ctx context.Context, d *Device, pool []byte) error {
+	if len(pool) < FORMAT_ENTROPY_LENGTH {
+		return ERR_ENTROPY_TOO_SHORT
+	}
+
+	err := callSuccess(ctx, d, hid.PREFIX_FORMAT, &models.FormatWalletArea{InitialEntropyPool: pool})
+	if err == nil {
+		d.wallet = -1
+		if d.features != nil {
+			d.features.IsFormatted = true
+		}
+	}
+	return err
+}
+
+// ChangeEncryptionKey changes the password of the loaded wallet. An
+// empty password makes the wallet unencrypted. The device asks for a
+// button press first.
+func ChangeEncryptionKey(ctx context.Context, d *Device, password []byte) error {
+	if d.wallet < 0 {
+		return ERR_NO_WALLET_LOADED
+	}
+
+	m := &models.ChangeEncryptionKey{}
+	if len(password) > 0 {
+		m.Password = password
+	}
+
+	return callSuccess(ctx, d, hid.PREFIX_CHANGE_ENCRYPTION_KEY, m)
+}
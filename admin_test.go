@@ -0,0 +1,54 @@
+package bitlox_test
+
+import (
+	"bitlox"
+
+	"bytes"
+	"context"
+	"testing"
+)
+
+// TestChangeDeviceName checks a new session sees the name set in the
+// last one
+func TestChangeDeviceName(t *testing.T) {
+	_, d := openSimulator(t, "Test wallet")
+	ctx := context.Background()
+
+	if err := bitlox.ChangeDeviceName(ctx, d, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	features, err := bitlox.Connect(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := bytes.TrimRight(features.DeviceName, "\x00"); string(name) != "Renamed" {
+		t.Fatalf("device name is %q, expected %q", name, "Renamed")
+	}
+}
+
+// TestFormatWalletArea checks formatting erases the wallets and
+// unloads the loaded one
+func TestFormatWalletArea(t *testing.T) {
+	_, d := openSimulator(t, "First", "Second")
+	ctx := context.Background()
+
+	if err := bitlox.FormatWalletArea(ctx, d, make([]byte, bitlox.FORMAT_ENTROPY_LENGTH-1)); err != bitlox.ERR_ENTROPY_TOO_SHORT {
+		t.Fatalf("expected ERR_ENTROPY_TOO_SHORT, got %v", err)
+	}
+	if err := bitlox.LoadWallet(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := bitlox.FormatWalletArea(ctx, d, make([]byte, bitlox.FORMAT_ENTROPY_LENGTH)); err != nil {
+		t.Fatal(err)
+	}
+	wallets, err := bitlox.GetWallets(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wallets) != 0 {
+		t.Fatalf("%d wallets left after formatting", len(wallets))
+	}
+	if err := bitlox.ResetPIN(ctx, d); err != bitlox.ERR_NO_WALLET_LOADED {
+		t.Fatalf("expected ERR_NO_WALLET_LOADED, got %v", err)
+	}
+}
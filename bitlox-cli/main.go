@@ -1,17 +1,36 @@
 package main
 
 import (
+	"github.com/btcsuite/btcutil"
 	"github.com/spf13/cobra"
 
 	"bitlox"
 	"bitlox/btcinfo"
+	"bitlox/hid"
 	"bitlox/logger"
+	models "bitlox/proto"
+	"bitlox/simulator"
 	"bitlox/wallet"
+
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var UNIT = btcinfo.UnitBTC
 
+const DEFAULT_FEE_RATE = 20
+
 // command line flags
 var (
 	walletNumber int
@@ -20,12 +39,47 @@ var (
 	unit         string
 	address      string
 	chainIndex   int
+	simulate     bool
+	simulateOtp  bool
+	deviceName   string
+	timeout      time.Duration
+	pingCount    int
+	walletName   string
+	hidden       bool
+	passwdStdin  bool
+	newNumber    int
+	encrypt      bool
+	passphrase   bool
+	assumeYes    bool
+	removePasswd bool
+	passwdEnv    string
+	passwdFD     int
+	addressIndex uint32
+	entropyBytes int
+	entropyFmt   string
+	backupCrypt  bool
+	backupDest   uint32
+	feeRate      int64
+	coinSelect   string
+	minChange    int64
+	psbtOutput   string
+	dryRun       bool
+	mockChain    string
+	broadcastURL string
+	pingInterval time.Duration
+	traceFile    string
+	replayFile   string
 )
 
 // global vars to store things
 var w *wallet.Wallet
 var dev *bitlox.Device
 
+// ctx is cancelled by Ctrl-C. Each exchange with the device is also
+// bound by --timeout, see deviceContext.
+var ctx = context.Background()
+var cancel context.CancelFunc = func() {}
+
 func main() {
 
 	appCmd := &cobra.Command{
@@ -38,7 +92,53 @@ func main() {
 
 	appCmd.PersistentFlags().StringVarP(&unit, "unit", "u", "btc", "Specify the unit for displaying values")
 	appCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
-	appCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Show debug messages (very verbose)")
+	appCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Show debug messages (very verbose), including every frame sent to and from the device with passwords, seeds and key material blanked out")
+	appCmd.PersistentFlags().StringVarP(&deviceName, "device", "d", "", "Select a device by index, path, serial or UUID (see the devices command)")
+	appCmd.PersistentFlags().DurationVar(&timeout, "timeout", 2*time.Minute, "Give up on each exchange with the device after this long (0 waits forever)")
+	appCmd.PersistentFlags().StringVar(&traceFile, "trace", "", "Record every report sent to and from the device to this file, with passwords, seeds and key material blanked out, to send with a bug report. --debug alone writes no file")
+	appCmd.PersistentFlags().StringVar(&replayFile, "replay", "", "Replay a recorded trace instead of talking to a device")
+	appCmd.PersistentFlags().BoolVar(&simulate, "simulator", false, "Talk to an in-process simulated device instead of hardware")
+	appCmd.PersistentFlags().BoolVar(&simulateOtp, "simulator-otp", false, "Have the simulated device ask for a one time password before destructive commands, to try the OTP prompt")
+	appCmd.PersistentFlags().StringVar(&mockChain, "mock-chain", "", "Read balances and unspent outputs from this JSON file instead of the network, and do not really broadcast")
+	appCmd.PersistentFlags().StringVar(&broadcastURL, "broadcast-url", "", "Broadcast transactions by POSTing their hex to this URL, such as https://blockstream.info/api/tx, instead of toshi.io. An Esplora URL like that one is also where psbt create fetches previous transactions from")
+	appCmd.PersistentFlags().StringVar(&passwdEnv, "password-env", "", "Read wallet passwords from this environment variable instead of prompting")
+	appCmd.PersistentFlags().IntVar(&passwdFD, "password-fd", -1, "Read the wallet password from the first line of this file descriptor instead of prompting")
+
+	devicesCmd := &cobra.Command{
+		Use:   "devices",
+		Short: "List attached devices",
+		Long: `List attached devices
+
+Any of the index, path, serial or UUID shown can be passed to --device to pick that device.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			setup()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			devices()
+		},
+	}
+
+	infoCmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show device features",
+		Run: func(cmd *cobra.Command, args []string) {
+			info()
+		},
+	}
+
+	pingCmd := &cobra.Command{
+		Use:   "ping",
+		Short: "Check the device is alive",
+		Long: `Check the device is alive
+
+Pings the device and checks it is still in the session started when connecting. If the device was reset in between, a new session is started.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ping()
+		},
+	}
+
+	pingCmd.Flags().IntVarP(&pingCount, "count", "c", 1, "Number of pings to send (0 pings until interrupted)")
+	pingCmd.Flags().DurationVarP(&pingInterval, "interval", "i", time.Second, "Time between pings")
 
 	walletCmd := &cobra.Command{
 		Use:   "wallet <wallet number>",
@@ -117,25 +217,496 @@ Sign a message with the specified address or address chain index (receive addres
 	signCmd.Flags().IntVarP(&chainIndex, "chain-index", "i", -1, "Specify the address chain index")
 	signCmd.Flags().StringVarP(&address, "address", "a", "", "Specify the address")
 
-	walletCmd.AddCommand(balanceCmd, addressesCmd, signCmd)
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a new wallet on the device",
+		Long: `Create a new wallet on the device
+
+The wallet is encrypted with a password read from the first line of stdin when --password-stdin is given, otherwise it is unencrypted. Confirm on the device when asked.`,
+		PersistentPreRun: appPreRun,
+		Run: func(cmd *cobra.Command, args []string) {
+			createWallet()
+		},
+	}
+
+	createCmd.Flags().StringVarP(&walletName, "name", "n", "", "Name of the new wallet (at most 39 bytes)")
+	createCmd.Flags().BoolVar(&hidden, "hidden", false, "Make the wallet hidden")
+	createCmd.Flags().BoolVar(&passwdStdin, "password-stdin", false, "Read the wallet password from stdin")
+	createCmd.Flags().IntVar(&newNumber, "number", -1, "Wallet number to create (defaults to the first free one)")
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a wallet onto the device from a BIP39 mnemonic",
+		Long: `Restore a wallet onto the device from a BIP39 mnemonic
+
+The mnemonic is read from the terminal without echo and checked against the wordlist and its checksum before anything is sent. With --passphrase the BIP39 passphrase is asked for as well, and with --encrypt a password to encrypt the wallet on the device. When stdin is not a terminal the mnemonic, passphrase and password are read from it one per line, in that order. Confirm on the device when asked.`,
+		PersistentPreRun: appPreRun,
+		Run: func(cmd *cobra.Command, args []string) {
+			restoreWallet()
+		},
+	}
+
+	restoreCmd.Flags().StringVarP(&walletName, "name", "n", "", "Name of the restored wallet (at most 39 bytes)")
+	restoreCmd.Flags().BoolVar(&hidden, "hidden", false, "Make the wallet hidden")
+	restoreCmd.Flags().BoolVar(&encrypt, "encrypt", false, "Ask for a password to encrypt the wallet")
+	restoreCmd.Flags().BoolVar(&passphrase, "passphrase", false, "Ask for the BIP39 passphrase of the mnemonic")
+	restoreCmd.Flags().IntVar(&newNumber, "number", -1, "Wallet number to restore to (defaults to the first free one)")
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a wallet from the device",
+		Long: `Delete a wallet from the device
+
+Shows the name and UUID of the wallet and asks for its name to be typed back before deleting it. Hidden wallets are not listed by the device, so deleting one needs --yes. Confirm on the device when asked.`,
+		PersistentPreRun: appPreRun,
+		Run: func(cmd *cobra.Command, args []string) {
+			deleteWallet(walletArg(args))
+		},
+	}
+
+	deleteCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask for confirmation")
+
+	renameCmd := &cobra.Command{
+		Use:   "rename <name>",
+		Short: "Rename a wallet",
+		Long: `Rename a wallet
+
+The name is stored null terminated in at most 40 bytes of UTF-8. Confirm on the device when asked.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				logger.Fatal("Missing new wallet name")
+			}
+			renameWallet(args[1])
+		},
+	}
+
+	showAddressCmd := &cobra.Command{
+		Use:   "show-address",
+		Short: "Show a receive address on the device",
+		Long: `Show a receive address on the device
+
+The device shows the address as a QR code while the address derived here from the wallet's public key is printed. Check that the two match before giving the address to anyone.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			showAddress()
+		},
+	}
 
-	appCmd.AddCommand(walletCmd)
+	showAddressCmd.Flags().Uint32VarP(&addressIndex, "index", "i", 0, "Receive chain index of the address")
+
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Back up a wallet on the device",
+		Long: `Back up a wallet on the device
+
+Starts the device's own backup of the wallet. Follow the instructions on the device.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			backupWallet()
+		},
+	}
+
+	backupCmd.Flags().BoolVar(&backupCrypt, "encrypted", false, "Encrypt the backup")
+	backupCmd.Flags().Uint32Var(&backupDest, "destination", 0, "Backup destination, as numbered by the device")
+
+	xpubCmd := &cobra.Command{
+		Use:   "xpub",
+		Short: "Export the wallet's extended public key",
+		Long: `Export the wallet's extended public key
+
+Fetches the master public key and chain code from the device, checks them against the key the wallet scan reports and writes the extended public key to stdout. It is enough to watch the wallet, but not to spend from it. Status messages go to stderr.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logger.SetOutput(os.Stderr)
+			walletPreRun(cmd, args)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			exportXpub()
+		},
+	}
+
+	sendCmd := &cobra.Command{
+		Use:   "send <address> <amount>",
+		Short: "Send bitcoin from the wallet",
+		Long: `Send bitcoin from the wallet
+
+Builds a transaction paying amount, in the --unit, to address from the wallet's unspent outputs, has the device sign it and broadcasts it, writing its hash to stdout. With --dry-run the signed transaction is shown and written to stdout as hex instead of being broadcast. Status messages go to stderr. Confirm on the device when asked.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logger.SetOutput(os.Stderr)
+			walletPreRun(cmd, args)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 3 {
+				logger.Fatal("Missing address or amount")
+			}
+			send(args[1], args[2])
+		},
+	}
+
+	sendCmd.Flags().Int64Var(&feeRate, "fee-rate", DEFAULT_FEE_RATE, "Fee rate in satoshis per byte")
+	sendCmd.Flags().StringVar(&coinSelect, "coin-select", "auto", "Coin selection strategy: auto, bnb, largest-first or random")
+	sendCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the signed transaction instead of broadcasting it")
+	sendCmd.Flags().Int64Var(&minChange, "min-change", 0, "Smallest change output in satoshis, smaller change goes to the fee (defaults to the dust limit)")
+
+	walletCmd.AddCommand(balanceCmd, addressesCmd, signCmd, createCmd, restoreCmd, deleteCmd, renameCmd, showAddressCmd, backupCmd, xpubCmd, sendCmd)
+
+	deviceCmd := &cobra.Command{
+		Use:   "device",
+		Short: "Administer the device",
+	}
+
+	deviceRenameCmd := &cobra.Command{
+		Use:   "rename <name>",
+		Short: "Change the device name",
+		Long: `Change the device name
+
+The name is stored null terminated in at most 40 bytes of UTF-8. Confirm on the device when asked.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				logger.Fatal("Missing new device name")
+			}
+			renameDevice(args[0])
+		},
+	}
+
+	resetPinCmd := &cobra.Command{
+		Use:   "reset-pin",
+		Short: "Change the PIN of a wallet",
+		Long: `Change the PIN of a wallet
+
+Loads the wallet given by --wallet and starts a PIN change on the device. The current and new PIN are entered on the device.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			resetPIN()
+		},
+	}
+
+	resetPinCmd.Flags().IntVarP(&walletNumber, "wallet", "w", -1, "Wallet to change the PIN of")
+
+	setLanguageCmd := &cobra.Command{
+		Use:   "set-language",
+		Short: "Choose the device language",
+		Long: `Choose the device language
+
+Brings up the language menu on the device.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			setLanguage()
+		},
+	}
+
+	formatCmd := &cobra.Command{
+		Use:   "format",
+		Short: "Erase every wallet on the device",
+		Long: `Erase every wallet on the device
+
+Formatting deletes all wallets, hidden ones included, and cannot be undone. Wallets can only be recovered from their mnemonics or backups. You are asked to type "format" before anything is sent, then confirm on the device. The device's random number generator is seeded with entropy gathered on this computer.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			format()
+		},
+	}
+
+	formatCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask for confirmation")
+
+	changePasswordCmd := &cobra.Command{
+		Use:   "change-password",
+		Short: "Change the password of a wallet",
+		Long: `Change the password of a wallet
+
+Loads the wallet given by --wallet and sets a new password, read from the terminal without echo (or the first line of stdin when it is not a terminal). --remove makes the wallet unencrypted instead. Confirm on the device when asked.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			changePassword()
+		},
+	}
+
+	changePasswordCmd.Flags().IntVarP(&walletNumber, "wallet", "w", -1, "Wallet to change the password of")
+	changePasswordCmd.Flags().BoolVar(&removePasswd, "remove", false, "Remove the password, leaving the wallet unencrypted")
+
+	bulkReadCmd := &cobra.Command{
+		Use:   "bulk-read <file>",
+		Short: "Save the bulk storage area to a file",
+		Long: `Save the bulk storage area to a file
+
+Prints the length and SHA-256 of what was saved, keep them with the file to check it later.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				logger.Fatal("Missing file name")
+			}
+			bulkRead(args[0])
+		},
+	}
+
+	bulkWriteCmd := &cobra.Command{
+		Use:   "bulk-write <file>",
+		Short: "Replace the bulk storage area with a file",
+		Long: `Replace the bulk storage area with a file
+
+After writing, the bulk storage area is read back and its length and SHA-256 compared with the file. Confirm on the device when asked.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				logger.Fatal("Missing file name")
+			}
+			bulkWrite(args[0])
+		},
+	}
+
+	deviceCmd.AddCommand(deviceRenameCmd, resetPinCmd, setLanguageCmd, formatCmd, changePasswordCmd, bulkReadCmd, bulkWriteCmd)
+
+	entropyCmd := &cobra.Command{
+		Use:   "entropy",
+		Short: "Read random bytes from the device",
+		Long: `Read random bytes from the device
+
+Writes entropy from the device's hardware random number generator to stdout, as hex or base64 on one line, or as raw bytes. Status messages go to stderr.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logger.SetOutput(os.Stderr)
+			appPreRun(cmd, args)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			entropy()
+		},
+	}
+
+	entropyCmd.Flags().IntVarP(&entropyBytes, "bytes", "n", 32, "Number of bytes to read")
+	entropyCmd.Flags().StringVarP(&entropyFmt, "format", "f", "hex", "Output format: hex, base64 or raw")
+
+	psbtCmd := &cobra.Command{
+		Use:   "psbt",
+		Short: "Work with partially signed transactions (BIP174)",
+		Long: `Work with partially signed transactions (BIP174)
+
+PSBTs are read from a file, or stdin when the file is "-", either binary or as base64, and written as base64 to stdout or the --output file. Status messages go to stderr.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logger.SetOutput(os.Stderr)
+			setup()
+		},
+	}
+
+	psbtCreateCmd := &cobra.Command{
+		Use:   "create <address> <amount>",
+		Short: "Create an unsigned PSBT from a wallet",
+		Long: `Create an unsigned PSBT from a wallet
+
+Builds a transaction paying amount, in the --unit, to address from the unspent outputs of the wallet given by --wallet, as the send command does, and exports it unsigned. Each input and the change output carry the BIP32 derivation of their key, so the device can later sign it. The transactions the inputs spend from are included too, which toshi.io cannot give: pass an Esplora --broadcast-url to fetch them.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			getDevice()
+			loadWalletFlag()
+			scanWallet()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				logger.Fatal("Missing address or amount")
+			}
+			psbtCreate(args[0], args[1])
+		},
+	}
+
+	psbtCreateCmd.Flags().IntVarP(&walletNumber, "wallet", "w", -1, "Wallet to spend from")
+	psbtCreateCmd.Flags().Int64Var(&feeRate, "fee-rate", DEFAULT_FEE_RATE, "Fee rate in satoshis per byte")
+	psbtCreateCmd.Flags().StringVar(&coinSelect, "coin-select", "auto", "Coin selection strategy: auto, bnb, largest-first or random")
+	psbtCreateCmd.Flags().Int64Var(&minChange, "min-change", 0, "Smallest change output in satoshis, smaller change goes to the fee (defaults to the dust limit)")
+	psbtCreateCmd.Flags().StringVarP(&psbtOutput, "output", "o", "", "Write the PSBT to a file instead of stdout")
+
+	psbtSignCmd := &cobra.Command{
+		Use:   "sign <file>",
+		Short: "Sign the inputs of a PSBT that belong to a wallet",
+		Long: `Sign the inputs of a PSBT that belong to a wallet
+
+Inputs are recognised as belonging to the wallet given by --wallet by their BIP32 derivation, and the device signs them all at once. Other inputs are left for their own signers. Confirm on the device when asked.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			getDevice()
+			loadWalletFlag()
+			scanWallet()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				logger.Fatal("Missing PSBT file")
+			}
+			psbtSign(args[0])
+		},
+	}
+
+	psbtSignCmd.Flags().IntVarP(&walletNumber, "wallet", "w", -1, "Wallet to sign with")
+	psbtSignCmd.Flags().StringVarP(&psbtOutput, "output", "o", "", "Write the PSBT to a file instead of stdout")
+
+	psbtFinalizeCmd := &cobra.Command{
+		Use:   "finalize <file>",
+		Short: "Finalize a signed PSBT into a raw transaction",
+		Long: `Finalize a signed PSBT into a raw transaction
+
+Turns the signatures of every input into its final script and writes the signed raw transaction to stdout as hex. Inputs must spend P2PKH or P2WPKH outputs. No device is needed.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				logger.Fatal("Missing PSBT file")
+			}
+			psbtFinalize(args[0])
+		},
+	}
+
+	psbtDecodeCmd := &cobra.Command{
+		Use:   "decode <file>",
+		Short: "Show what a PSBT spends and pays",
+		Long: `Show what a PSBT spends and pays
+
+Lists the inputs with the outputs they spend, their signatures and derivations, then the outputs and the fee. No device is needed.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				logger.Fatal("Missing PSBT file")
+			}
+			psbtDecode(args[0])
+		},
+	}
+
+	psbtCmd.AddCommand(psbtCreateCmd, psbtSignCmd, psbtFinalizeCmd, psbtDecodeCmd)
+
+	broadcastCmd := &cobra.Command{
+		Use:   "broadcast <hex|file>",
+		Short: "Send a signed transaction to the network",
+		Long: `Send a signed transaction to the network
+
+The transaction is given as hex, or as a file holding it as hex or raw bytes ("-" for stdin). It is decoded and shown before being broadcast, and its hash is written to stdout. Status messages go to stderr. No device is needed.`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logger.SetOutput(os.Stderr)
+			setup()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				logger.Fatal("Missing transaction")
+			}
+			raw := readRawTx(args[0])
+			printTx(os.Stderr, parseTx(raw))
+			broadcast(raw)
+		},
+	}
+
+	appCmd.AddCommand(devicesCmd, infoCmd, pingCmd, walletCmd, deviceCmd, entropyCmd, psbtCmd, broadcastCmd)
+	appCmd.SetArgs(walletArgsFirst(os.Args[1:], walletCmd))
 	appCmd.Execute()
+	if dev != nil {
+		dev.Close()
+	}
+	cancel()
 
 }
 
 func getDevice() {
-	logger.Log("Getting device connection")
-	var err error
-	dev, err = bitlox.GetDevice()
-	if err != nil {
+	var t hid.Transport
+	switch {
+	case replayFile != "":
+		logger.Log("Replaying", replayFile)
+		f, err := os.Open(replayFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		replay, err := hid.NewReplay(f)
+		f.Close()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		t = replay
+	case simulate:
+		logger.Log("Using simulated device")
+		sim, err := simulator.New(simulator.TestSeed, "Simulated wallet")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		sim.RequireOtp = simulateOtp
+		t = sim
+	default:
+		logger.Log("Getting device connection")
+		openCtx, stop := deviceContext()
+		var err error
+		t, err = bitlox.OpenTransport(openCtx, deviceName)
+		stop()
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if traceFile != "" {
+		f, err := os.OpenFile(traceFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Log("Recording device session to", traceFile)
+		t = hid.NewRecorder(t, f)
+	}
+
+	dev = bitlox.NewDevice(t)
+	dev.SetTimeout(timeout)
+	if _, err := bitlox.Connect(ctx, dev); err != nil {
 		logger.Fatal(err)
 	}
+	setupPasswords()
+	dev.SetInterjector(&bitlox.Interjections{Pin: passwords.Password, Otp: promptOtp})
+}
+
+func devices() {
+	var list []*bitlox.DeviceInfo
+	if simulate {
+		sim, err := simulator.New(simulator.TestSeed)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		list = []*bitlox.DeviceInfo{{Path: "simulator", UUID: sim.UUID()}}
+	} else {
+		listCtx, stop := deviceContext()
+		var err error
+		list, err = bitlox.ListDevices(listCtx)
+		stop()
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+	if len(list) == 0 {
+		logger.Fatal("No devices attached")
+	}
+	logger.Log("\nDEVICES")
+	for index, device := range list {
+		logger.Logf("%-3d %-32s %-20s %s\n", index, device.UUIDString(), device.Serial, device.Path)
+	}
+}
+
+func info() {
+	features := dev.Features()
+	logger.Log("\nDEVICE")
+	logger.Logf("%-12s %s\n", "name", features.DeviceNameString())
+	logger.Logf("%-12s %s\n", "vendor", features.Vendor)
+	logger.Logf("%-12s %s\n", "firmware", features.Version())
+	if features.Config != "" {
+		logger.Logf("%-12s %s\n", "config", features.Config)
+	}
+	logger.Logf("%-12s %t\n", "formatted", features.IsFormatted)
+	logger.Logf("%-12s %t\n", "pin", features.Pin)
+	logger.Logf("%-12s %t\n", "otp", features.Otp)
+	logger.Logf("%-12s %t\n", "spv", features.Spv)
+	logger.Logf("%-12s %s\n", "algorithms", strings.Join(features.AlgorithmNames(), ", "))
+	if verbose {
+		logger.Logf("%-12s %t\n", "debug link", features.DebugLink)
+		logger.Logf("%-12s %x\n", "session", dev.SessionID())
+	}
+}
+
+func ping() {
+	for i := 0; pingCount == 0 || i < pingCount; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pingInterval):
+			}
+		}
+		start := time.Now()
+		reset, err := bitlox.EnsureSession(ctx, dev)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if reset {
+			logger.Logf("%-3d device was reset, new session %x\n", i, dev.SessionID())
+		} else {
+			logger.Logf("%-3d alive, session ok, %s\n", i, time.Since(start))
+		}
+	}
 }
 
 func walletList() {
 
-	wallets, err := bitlox.GetWallets(dev)
+	wallets, err := bitlox.GetWallets(ctx, dev)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -188,7 +759,7 @@ func sign(message []byte) {
 	logger.Log("Signing. Check Device")
 	addresses := w.Addresses(wallet.CHAIN_INDEX_RECEIVE)
 	address := addresses[chainIndex]
-	sig, err := bitlox.SignMessage(dev, address, message)
+	sig, err := bitlox.SignMessage(ctx, dev, address, message)
 	if err != nil {
 		logger.Fatal(err, string(sig))
 	}
@@ -198,10 +769,371 @@ func sign(message []byte) {
 	logger.Logf(`bitcoin-cli verifymessage %s "%s" "%s"`+"\n", address, sig, message)
 }
 
+func createWallet() {
+	if walletName == "" {
+		logger.Fatal("A wallet name is required, use --name")
+	}
+
+	var password []byte
+	if passwdStdin {
+		var err error
+		password, err = readLine(stdin)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if len(password) == 0 {
+			logger.Fatal("Empty password on stdin")
+		}
+	}
+
+	number := targetWalletNumber()
+
+	logger.Logf("Creating wallet %d. Check Device\n", number)
+	err := bitlox.NewWallet(ctx, dev, number, walletName, password, hidden)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Wallet created")
+}
+
+func restoreWallet() {
+	if walletName == "" {
+		logger.Fatal("A wallet name is required, use --name")
+	}
+
+	mnemonic, err := readSecret("Mnemonic: ")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	var pass []byte
+	if passphrase {
+		pass, err = readSecret("BIP39 passphrase: ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+	seed, err := bitlox.SeedFromMnemonic(string(mnemonic), string(pass))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	var password []byte
+	if encrypt {
+		password, err = readNewSecret("Wallet password: ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if len(password) == 0 {
+			logger.Fatal("Empty password")
+		}
+	}
+
+	number := targetWalletNumber()
+
+	logger.Logf("Restoring wallet %d. Check Device\n", number)
+	err = bitlox.RestoreWallet(ctx, dev, number, walletName, password, hidden, seed)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Wallet restored")
+}
+
+func exportXpub() {
+	logger.Log("Getting master public key. Check Device")
+	key, err := bitlox.GetMasterPublicKey(ctx, dev)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	fmt.Println(key)
+}
+
+func send(to string, value string) {
+	spend := newSpend(to, value)
+
+	logger.Log("Signing transaction. Check Device")
+	tx, err := bitlox.SignTransaction(ctx, dev, spend)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if dryRun {
+		printTx(os.Stdout, tx)
+		fmt.Println(hex.EncodeToString(serializeTx(tx)))
+		return
+	}
+	broadcast(serializeTx(tx))
+}
+
+// newSpend builds a spend from w with the coin selection flags and
+// shows what it pays
+func newSpend(to string, value string) *wallet.Spend {
+	amount, err := parseAmount(value)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	strategy, ok := wallet.Strategies[coinSelect]
+	if !ok {
+		logger.Fatal("Unknown coin selection strategy", coinSelect)
+	}
+	logger.Log("Loading unspent outputs")
+	w.LoadBalance()
+	opts := &wallet.SelectOptions{FeeRate: btcinfo.Satoshi(feeRate), MinChange: btcinfo.Satoshi(minChange)}
+	spend, err := w.NewSpend(to, amount, strategy, opts)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Logf("Sending %s to %s\n", amount.Format(UNIT), to)
+	logger.Logf("Fee %s from %d inputs\n", spend.Fee.Format(UNIT), len(spend.Inputs))
+	if spend.Change != nil {
+		logger.Logf("Change %s to %s\n", spend.ChangeAmount.Format(UNIT), spend.Change)
+	}
+	return spend
+}
+
+// parseAmount reads an amount given in the --unit
+func parseAmount(value string) (btcinfo.Satoshi, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	amount, err := btcutil.NewAmount(f * math.Pow10(int(UNIT)))
+	if err != nil {
+		return 0, err
+	}
+	return btcinfo.Satoshi(amount), nil
+}
+
+func backupWallet() {
+	logger.Logf("Backing up wallet %d. Check Device\n", walletNumber)
+	err := bitlox.BackupWallet(ctx, dev, backupCrypt, backupDest)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Backup done")
+}
+
+func bulkRead(file string) {
+	data, err := bitlox.GetBulk(ctx, dev)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	err = os.WriteFile(file, data, 0600)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Logf("Saved %d bytes to %s\nsha256 %x\n", len(data), file, sha256.Sum256(data))
+}
+
+func bulkWrite(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Logf("Writing %d bytes from %s\nsha256 %x\nCheck Device\n", len(data), file, sha256.Sum256(data))
+	err = bitlox.WriteBulk(ctx, dev, data)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Written and verified")
+}
+
+func entropy() {
+	if entropyBytes < 1 {
+		logger.Fatal("--bytes must be at least 1")
+	}
+	var encode func([]byte) string
+	switch entropyFmt {
+	case "hex":
+		encode = hex.EncodeToString
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	case "raw":
+	default:
+		logger.Fatalf("Unknown format %q, use hex, base64 or raw\n", entropyFmt)
+	}
+
+	buf := make([]byte, entropyBytes)
+	_, err := io.ReadFull(bitlox.NewEntropyReader(ctx, dev), buf)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if encode == nil {
+		_, err = os.Stdout.Write(buf)
+	} else {
+		_, err = fmt.Fprintln(os.Stdout, encode(buf))
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func showAddress() {
+	a, err := w.ReceiveAddress(addressIndex)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Logf("Receive address %d of wallet %d:\n\n  %s\n\n", addressIndex, walletNumber, a)
+	logger.Log("Check it matches the QR code on the device, then press the button to dismiss it")
+	err = bitlox.DisplayAddressAsQR(ctx, dev, addressIndex)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func deleteWallet(number int) {
+	if !assumeYes {
+		wallets, err := bitlox.GetWallets(ctx, dev)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		var info *models.WalletInfo
+		for _, wi := range wallets {
+			if int(wi.Number) == number {
+				info = wi
+			}
+		}
+		if info == nil {
+			logger.Fatalf("Wallet %d is not listed by the device, use --yes to delete it anyway\n", number)
+		}
+		logger.Logf("Wallet %d\n  Name: %s\n  UUID: %x\n", number, info.NameString(), info.UUID)
+		logger.Log("This cannot be undone without the wallet's mnemonic or a backup.")
+		fmt.Fprint(os.Stderr, "Type the wallet name to delete it: ")
+		typed, err := readLine(stdin)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if string(typed) != info.NameString() {
+			logger.Fatal("Name does not match, not deleting")
+		}
+	}
+
+	logger.Logf("Deleting wallet %d. Check Device\n", number)
+	err := bitlox.DeleteWallet(ctx, dev, byte(number))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Wallet deleted")
+}
+
+func renameWallet(name string) {
+	logger.Logf("Renaming wallet %d to %s. Check Device\n", walletNumber, name)
+	err := bitlox.RenameWallet(ctx, dev, name)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Wallet renamed")
+}
+
+func renameDevice(name string) {
+	logger.Logf("Renaming device to %s. Check Device\n", name)
+	err := bitlox.ChangeDeviceName(ctx, dev, name)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Device renamed")
+}
+
+func resetPIN() {
+	loadWalletFlag()
+	logger.Log("Change the PIN on the device")
+	err := bitlox.ResetPIN(ctx, dev)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("PIN changed")
+}
+
+func setLanguage() {
+	logger.Log("Choose a language on the device")
+	err := bitlox.ResetLang(ctx, dev)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Language set")
+}
+
+func format() {
+	if !assumeYes {
+		logger.Log("Formatting erases every wallet on the device, hidden ones included.")
+		logger.Log("This cannot be undone without the wallets' mnemonics or backups.")
+		fmt.Fprint(os.Stderr, `Type "format" to continue: `)
+		typed, err := readLine(stdin)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if string(typed) != "format" {
+			logger.Fatal("Not formatting")
+		}
+	}
+
+	pool := make([]byte, bitlox.FORMAT_ENTROPY_LENGTH)
+	if _, err := rand.Read(pool); err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Log("Formatting. Check Device")
+	err := bitlox.FormatWalletArea(ctx, dev, pool)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Device formatted")
+}
+
+func changePassword() {
+	loadWalletFlag()
+
+	var password []byte
+	if !removePasswd {
+		var err error
+		password, err = readNewSecret("New wallet password: ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if len(password) == 0 {
+			logger.Fatal("Empty password, use --remove to make the wallet unencrypted")
+		}
+	}
+
+	logger.Logf("Changing the password of wallet %d. Check Device\n", walletNumber)
+	err := bitlox.ChangeEncryptionKey(ctx, dev, password)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Password changed")
+}
+
+// loadWalletFlag loads the wallet given with --wallet
+func loadWalletFlag() {
+	if walletNumber < 0 || walletNumber > 0xff {
+		logger.Fatal("A wallet number is required, use --wallet")
+	}
+	unlockWallet(walletNumber)
+}
+
+// targetWalletNumber is --number, or the first free wallet number if
+// it was not given
+func targetWalletNumber() uint32 {
+	if newNumber >= 0 {
+		return uint32(newNumber)
+	}
+	number, err := bitlox.FreeWalletNumber(ctx, dev)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return number
+}
+
 func appPreRun(cmd *cobra.Command, args []string) {
+	setup()
+	getDevice()
+}
+
+func setup() {
 	if debug {
 		logger.EnableDebug()
 	}
+	ctx, cancel = signal.NotifyContext(context.Background(), os.Interrupt)
 	switch unit {
 	case "bitcoin", "btc", "BTC":
 		UNIT = btcinfo.UnitBTC
@@ -212,20 +1144,64 @@ func appPreRun(cmd *cobra.Command, args []string) {
 	case "satoshi":
 		UNIT = btcinfo.UnitSatoshi
 	}
-	getDevice()
+	if broadcastURL != "" {
+		btcinfo.SetBackend(&btcinfo.Toshi{BroadcastURL: broadcastURL})
+	}
+	if mockChain != "" {
+		mock, err := btcinfo.LoadMock(mockChain)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		btcinfo.SetBackend(mock)
+	}
 }
 
-func walletPreRun(cmd *cobra.Command, args []string) {
-	if len(args) == 0 {
-		logger.Fatal("Wallet number is required for the balance command")
+// deviceContext bounds talking to a device outside of a Device, such
+// as finding one to open, by --timeout
+func deviceContext() (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
 	}
-	walletNumber, err := strconv.Atoi(args[0])
-	if err != nil {
-		logger.Fatal("Invalid wallet number")
+	return context.WithCancel(ctx)
+}
+
+// walletArgsFirst lets the wallet number come before the subcommand,
+// as in "wallet 0 rename savings", by moving it after the subcommand
+// where cobra expects it
+func walletArgsFirst(args []string, walletCmd *cobra.Command) []string {
+	for i := 0; i+2 < len(args); i++ {
+		if args[i] != walletCmd.Name() {
+			continue
+		}
+		if _, err := strconv.Atoi(args[i+1]); err != nil {
+			return args
+		}
+		for _, sub := range walletCmd.Commands() {
+			if sub.Name() == args[i+2] {
+				moved := append([]string{}, args[:i+1]...)
+				moved = append(moved, args[i+2], args[i+1])
+				return append(moved, args[i+3:]...)
+			}
+		}
+		return args
 	}
-	if walletNumber < 0 {
+	return args
+}
+
+// walletArg parses the wallet number from the first argument
+func walletArg(args []string) int {
+	if len(args) == 0 {
+		logger.Fatal("Wallet number is required")
+	}
+	number, err := strconv.Atoi(args[0])
+	if err != nil || number < 0 || number > 0xff {
 		logger.Fatal("Invalid wallet number")
 	}
+	return number
+}
+
+func walletPreRun(cmd *cobra.Command, args []string) {
+	walletNumber = walletArg(args)
 	if cmd.Use == "sign" {
 		if chainIndex < 0 && address == "" {
 			logger.Fatal("You must supply either --chain-index or --address to sign a message")
@@ -241,17 +1217,17 @@ func walletPreRun(cmd *cobra.Command, args []string) {
 
 	// get the wallet in question
 	logger.Log("Loading wallet info")
-	err = bitlox.LoadWallet(dev, byte(walletNumber))
-	if err != nil {
-		logger.Fatal(err)
-	}
+	unlockWallet(walletNumber)
+	scanWallet()
+}
 
+// scanWallet sets w from the xpub of the loaded wallet
+func scanWallet() {
 	logger.Log("Getting public key")
-	xpub, err := bitlox.ScanWallet(dev)
+	xpub, err := bitlox.ScanWallet(ctx, dev)
 	if err != nil {
 		logger.Fatal(err)
 	}
 
 	w = wallet.WalletFromXpub(xpub)
-
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/wire"
+
+	"bitlox"
+	"bitlox/btcinfo"
+	"bitlox/simulator"
+
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// simulatedWallet opens a simulator and sets dev and w the way the
+// commands do, with the mock as the backend and receive index 0 funded
+func simulatedWallet(t *testing.T) *btcinfo.Mock {
+	sim, err := simulator.New(simulator.TestSeed, "Simulated wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = context.Background()
+	dev, err = bitlox.Open(ctx, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dev.Close() })
+	if err := bitlox.LoadWallet(ctx, dev, 0); err != nil {
+		t.Fatal(err)
+	}
+	scanWallet()
+
+	a, err := w.ReceiveAddress(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := a.PkScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := btcinfo.NewMock()
+	mock.Addresses[a.String()] = &btcinfo.Address{Received: 100000, Balance: 100000}
+	mock.Unspent[a.String()] = []*btcinfo.Output{{
+		HashStr:   strings.Repeat("bb", 32),
+		Value:     100000,
+		ScriptStr: hex.EncodeToString(script),
+	}}
+	btcinfo.SetBackend(mock)
+	t.Cleanup(func() { btcinfo.SetBackend(&btcinfo.Toshi{}) })
+
+	coinSelect, feeRate, unit = "auto", 1, "satoshi"
+	UNIT = btcinfo.UnitSatoshi
+	return mock
+}
+
+// captureStdout returns what f writes to stdout
+func captureStdout(t *testing.T, f func()) string {
+	r, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = wr
+	defer func() { os.Stdout = stdout }()
+	f()
+	wr.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// lastLine is the last line of out, where send writes the hash or hex
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return lines[len(lines)-1]
+}
+
+func TestSendDryRun(t *testing.T) {
+	mock := simulatedWallet(t)
+	to, err := w.ReceiveAddress(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dryRun = true
+	defer func() { dryRun = false }()
+	out := captureStdout(t, func() { send(to.String(), "50000") })
+
+	if n := len(mock.Broadcasts()); n != 0 {
+		t.Fatalf("--dry-run broadcast %d transactions", n)
+	}
+	raw, err := hex.DecodeString(lastLine(out))
+	if err != nil {
+		t.Fatalf("--dry-run did not end with the transaction hex: %s", err)
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxIn) != 1 || len(tx.TxIn[0].SignatureScript) == 0 {
+		t.Fatalf("expected one signed input")
+	}
+}
+
+func TestSendBroadcast(t *testing.T) {
+	mock := simulatedWallet(t)
+	to, err := w.ReceiveAddress(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() { send(to.String(), "50000") })
+
+	broadcasts := mock.Broadcasts()
+	if len(broadcasts) != 1 {
+		t.Fatalf("broadcast %d transactions, expected 1", len(broadcasts))
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(broadcasts[0])); err != nil {
+		t.Fatal(err)
+	}
+	if hash := lastLine(out); hash != tx.TxHash().String() {
+		t.Fatalf("printed %q, broadcast %s", hash, tx.TxHash())
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"golang.org/x/term"
+
+	"bitlox"
+	"bitlox/logger"
+
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ERR_SECRET_MISMATCH = errors.New("Entries do not match")
+
+// stdin is shared by everything reading lines so buffered input is
+// not lost between prompts
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads a single line without its line ending
+func readLine(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// readSecret prompts on stderr and reads a line from the terminal
+// without echoing it. When stdin is not a terminal the next line is
+// read from it instead, without a prompt.
+func readSecret(prompt string) ([]byte, error) {
+	if !stdinIsTerminal() {
+		return readLine(stdin)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return secret, err
+}
+
+// readNewSecret is readSecret, asking twice on a terminal to catch
+// typos
+func readNewSecret(prompt string) ([]byte, error) {
+	secret, err := readSecret(prompt)
+	if err != nil || !stdinIsTerminal() {
+		return secret, err
+	}
+	again, err := readSecret("Repeat " + prompt)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(secret, again) {
+		return nil, ERR_SECRET_MISMATCH
+	}
+	return secret, nil
+}
+
+// readLineContext is readLine(stdin) that gives up when ctx is done.
+// The read itself carries on in the background, so it should only be
+// used when nothing else will read stdin afterwards.
+func readLineContext(ctx context.Context) ([]byte, error) {
+	type result struct {
+		line []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := readLine(stdin)
+		done <- result{line, err}
+	}()
+	select {
+	case r := <-done:
+		return r.line, r.err
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return nil, ctx.Err()
+	}
+}
+
+// promptOtp asks for the one time password the device shows when it
+// wants a sensitive operation confirmed. An empty line cancels.
+func promptOtp(ctx context.Context) (string, error) {
+	logger.Log("The device is showing a one time password. Enter it to continue, or an empty line to cancel.")
+	fmt.Fprint(os.Stderr, "OTP: ")
+	line, err := readLineContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	otp := strings.TrimSpace(string(line))
+	if otp == "" {
+		return "", bitlox.ERR_CANCELLED
+	}
+	return otp, nil
+}
+
+// how many times a wallet password typed at the terminal may be wrong
+const PASSWORD_ATTEMPTS = 3
+
+// passwords answers the device's password requests, passwordAttempts
+// is how many of its answers are worth trying
+var passwords bitlox.PasswordProvider
+var passwordAttempts int
+
+// setupPasswords picks where wallet passwords come from: the variable
+// named by --password-env, the descriptor given by --password-fd, or
+// else a prompt. Only a prompt can give a different answer when the
+// first one is wrong.
+func setupPasswords() {
+	switch {
+	case passwdEnv != "":
+		passwords, passwordAttempts = bitlox.EnvPassword(passwdEnv), 1
+	case passwdFD >= 0:
+		passwords, passwordAttempts = &bitlox.FDPassword{FD: uintptr(passwdFD)}, 1
+	default:
+		passwords, passwordAttempts = bitlox.PasswordFunc(promptPassword), PASSWORD_ATTEMPTS
+	}
+}
+
+// promptPassword asks for a wallet password without echo. An empty
+// password cancels.
+func promptPassword(ctx context.Context) ([]byte, error) {
+	password, err := readSecret("Wallet password: ")
+	if err == nil && len(password) == 0 {
+		err = bitlox.ERR_CANCELLED
+	}
+	return password, err
+}
+
+// unlockWallet loads a wallet, asking for its password if the device
+// wants one
+func unlockWallet(number int) {
+	asked := 0
+	p := bitlox.PasswordFunc(func(ctx context.Context) ([]byte, error) {
+		asked++
+		if asked > 1 {
+			logger.Log("Wrong password, try again")
+		}
+		return passwords.Password(ctx)
+	})
+	err := bitlox.UnlockWallet(ctx, dev, byte(number), p, passwordAttempts)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
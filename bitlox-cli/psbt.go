@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bitlox"
+	"bitlox/btcinfo"
+	"bitlox/logger"
+	"bitlox/psbt"
+
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func psbtCreate(to string, value string) {
+	spend := newSpend(to, value)
+	p, err := w.NewPSBT(spend)
+	if err == btcinfo.ERR_NO_RAW_TRANSACTIONS {
+		logger.Fatalf("%s: give an Esplora --broadcast-url, such as https://blockstream.info/api/tx, to fetch the transactions the inputs spend from\n", err)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	writePSBT(p)
+}
+
+func psbtSign(file string) {
+	p := readPSBT(file)
+	logger.Log("Signing PSBT. Check Device")
+	n, err := bitlox.SignPSBT(ctx, dev, w, p)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Logf("Signed %d of %d inputs\n", n, len(p.Inputs))
+	writePSBT(p)
+}
+
+func psbtFinalize(file string) {
+	p := readPSBT(file)
+	if err := p.Finalize(); err != nil {
+		logger.Fatal(err)
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Finalized transaction", tx.TxHash())
+	fmt.Println(hex.EncodeToString(serializeTx(tx)))
+}
+
+func psbtDecode(file string) {
+	p := readPSBT(file)
+	fmt.Println("Transaction", p.UnsignedTx.TxHash())
+	for i, in := range p.Inputs {
+		fmt.Printf("Input %d: %s\n", i, p.UnsignedTx.TxIn[i].PreviousOutPoint)
+		if utxo, err := p.Utxo(i); err == nil {
+			fmt.Printf("  spends %s from %s\n", btcinfo.Satoshi(utxo.Value).Format(UNIT), scriptAddress(utxo.PkScript))
+		} else {
+			fmt.Printf("  spends unknown output: %s\n", err)
+		}
+		for _, d := range in.Bip32Derivation {
+			fmt.Printf("  key %x from %08x/%s\n", d.PubKey, d.Fingerprint, derivationPath(d.Path))
+		}
+		for _, sig := range in.PartialSigs {
+			fmt.Printf("  signed by %x\n", sig.PubKey)
+		}
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			fmt.Println("  finalized")
+		}
+	}
+	for i, out := range p.UnsignedTx.TxOut {
+		fmt.Printf("Output %d: %s to %s\n", i, btcinfo.Satoshi(out.Value).Format(UNIT), scriptAddress(out.PkScript))
+		for _, d := range p.Outputs[i].Bip32Derivation {
+			fmt.Printf("  key %x from %08x/%s\n", d.PubKey, d.Fingerprint, derivationPath(d.Path))
+		}
+	}
+	if fee, err := p.Fee(); err == nil {
+		fmt.Println("Fee", btcinfo.Satoshi(fee).Format(UNIT))
+	}
+	fmt.Println("Complete", p.IsComplete())
+}
+
+// readPSBT reads a PSBT from file, or stdin for "-"
+func readPSBT(file string) *psbt.Packet {
+	var (
+		b   []byte
+		err error
+	)
+	if file == "-" {
+		b, err = io.ReadAll(stdin)
+	} else {
+		b, err = os.ReadFile(file)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	p, err := psbt.Decode(b)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return p
+}
+
+// writePSBT writes p as base64 to --output, or stdout
+func writePSBT(p *psbt.Packet) {
+	text, err := p.Base64()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if psbtOutput == "" {
+		fmt.Println(text)
+		return
+	}
+	if err := os.WriteFile(psbtOutput, []byte(text+"\n"), 0600); err != nil {
+		logger.Fatal(err)
+	}
+	logger.Log("Saved PSBT to", psbtOutput)
+}
+
+// derivationPath writes path the usual way, with ' for hardened
+func derivationPath(path []uint32) string {
+	parts := make([]string, len(path))
+	for i, index := range path {
+		if index >= 0x80000000 {
+			parts[i] = fmt.Sprintf("%d'", index-0x80000000)
+		} else {
+			parts[i] = fmt.Sprint(index)
+		}
+	}
+	return strings.Join(parts, "/")
+}
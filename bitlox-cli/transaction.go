@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"bitlox/btcinfo"
+	"bitlox/logger"
+
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// broadcast sends raw to the network and prints its hash
+func broadcast(raw []byte) {
+	logger.Log("Broadcasting transaction")
+	hash, err := btcinfo.Broadcast(raw)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if mockChain != "" {
+		logger.Log("Not sent to the network, --mock-chain is set")
+	}
+	fmt.Println(hash)
+}
+
+// readRawTx reads a transaction given as hex, or from a file (stdin
+// for "-") as hex or raw bytes
+func readRawTx(arg string) []byte {
+	if raw, err := hex.DecodeString(arg); err == nil {
+		return raw
+	}
+	var (
+		b   []byte
+		err error
+	)
+	if arg == "-" {
+		b, err = io.ReadAll(stdin)
+	} else {
+		b, err = os.ReadFile(arg)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if raw, err := hex.DecodeString(string(bytes.TrimSpace(b))); err == nil {
+		return raw
+	}
+	return b
+}
+
+func parseTx(raw []byte) *wire.MsgTx {
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		logger.Fatal("Invalid transaction:", err)
+	}
+	return tx
+}
+
+func serializeTx(tx *wire.MsgTx) []byte {
+	raw := &bytes.Buffer{}
+	if err := tx.Serialize(raw); err != nil {
+		logger.Fatal(err)
+	}
+	return raw.Bytes()
+}
+
+// printTx shows what tx spends and pays
+func printTx(w io.Writer, tx *wire.MsgTx) {
+	fmt.Fprintln(w, "Transaction", tx.TxHash())
+	fmt.Fprintf(w, "Size %d bytes\n", tx.SerializeSize())
+	for i, in := range tx.TxIn {
+		fmt.Fprintf(w, "Input %d: %s\n", i, in.PreviousOutPoint)
+	}
+	for i, out := range tx.TxOut {
+		fmt.Fprintf(w, "Output %d: %s to %s\n", i, btcinfo.Satoshi(out.Value).Format(UNIT), scriptAddress(out.PkScript))
+	}
+}
+
+func scriptAddress(script []byte) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, &chaincfg.MainNetParams)
+	if err != nil || len(addrs) != 1 {
+		return fmt.Sprintf("script %x", script)
+	}
+	return addrs[0].EncodeAddress()
+}
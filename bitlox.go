@@ -1,7 +1,6 @@
 package bitlox
 
 import (
-	gohid "github.com/GeertJohan/go.hid"
 	"github.com/golang/protobuf/proto"
 
 	"bitlox/hid"
@@ -10,6 +9,7 @@ import (
 	"bitlox/wallet"
 
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
@@ -18,6 +18,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcutil"
 	"regexp"
+	"time"
 )
 
 var ERR_UNRECOGNIZED_RETURN = errors.New("Unrecogized command returned from device")
@@ -27,39 +28,56 @@ var ERR_INVALID_SIG = errors.New("Invalid signature")
 
 const MAX_MESSAGE_LENGTH = 0xffff
 
+const VENDOR_ID = 1003
+const PRODUCT_ID = 8271
+
+// Device is a BitLox reached over some hid.Transport
 type Device struct {
-	dev *gohid.Device
+	t           hid.Transport
+	dec         *hid.Decoder
+	interjector Interjector
+	sessionID   []byte
+	features    *models.Features
+	// wallet number last loaded, -1 for none
+	wallet int
+	// bound on each exchange, see SetTimeout
+	timeout time.Duration
+	// whether the password is the last answer the current call gave
+	pinAnswered bool
 }
 
-func (d *Device) Close() {
-	d.dev.Close()
+// NewDevice wraps an already opened transport
+func NewDevice(t hid.Transport) *Device {
+	return &Device{t: t, dec: hid.NewDecoder(), wallet: -1}
 }
 
-func GetDevice() (*Device, error) {
-	dev, err := hid.GetDevice(1003, 8271)
-	if err != nil {
-		return nil, err
-	}
-	return &Device{dev: dev}, nil
+func (d *Device) Close() error {
+	return d.t.Close()
 }
 
-func GetWallets(d *Device) ([]*models.WalletInfo, error) {
-	err := hid.Write(d.dev, hid.COMMAND_LIST_WALLETS)
+func GetDevice(ctx context.Context) (*Device, error) {
+	t, err := hid.GetDevice(VENDOR_ID, PRODUCT_ID)
 	if err != nil {
 		return nil, err
 	}
+	return Open(ctx, t)
+}
 
-	res := new(bytes.Buffer)
-	err = hid.Read(d.dev, res)
+func GetDeviceUUID(ctx context.Context, d *Device) ([]byte, error) {
+	uuid := &models.DeviceUUID{}
+
+	err := callExpect(ctx, d, hid.COMMAND_GET_DEVICE_UUID, nil, hid.RESPONSE_DEVICE_UUID, uuid)
 	if err != nil {
 		return nil, err
 	}
 
-	_, _, payload := hid.ParseResponse(res)
+	return uuid.UUID, nil
+}
 
+func GetWallets(ctx context.Context, d *Device) ([]*models.WalletInfo, error) {
 	wallets := &models.Wallets{}
 
-	err = proto.Unmarshal(payload, wallets)
+	err := callExpect(ctx, d, hid.COMMAND_LIST_WALLETS, nil, hid.RESPONSE_WALLETS, wallets)
 	if err != nil {
 		return nil, err
 	}
@@ -67,55 +85,25 @@ func GetWallets(d *Device) ([]*models.WalletInfo, error) {
 	return wallets.Wallets, nil
 }
 
-func LoadWallet(d *Device, number byte) error {
+// LoadWallet loads a wallet for the commands that use one. For an
+// encrypted wallet the device asks for the password through the
+// Device's Interjector, see UnlockWallet; a wrong one gives a
+// *WrongPasswordError.
+func LoadWallet(ctx context.Context, d *Device, number byte) error {
 
-	cmd := append(hid.PREFIX_LOAD_WALLET, number)
-
-	err := hid.Write(d.dev, cmd)
+	err := callSuccess(ctx, d, walletCommand(hid.PREFIX_LOAD_WALLET, number), nil)
 	if err != nil {
-		return err
+		return wrongPassword(d, err, number)
 	}
 
-	res := new(bytes.Buffer)
-	err = hid.Read(d.dev, res)
-	if err != nil {
-		return err
-	}
-
-	resCmd, _, payload := hid.ParseResponse(res)
-
-	if resCmd == hid.RESPONSE_SUCCESS {
-		return nil
-	} else if resCmd == hid.RESPONSE_ERROR {
-		failure := &models.Failure{}
-
-		err = proto.Unmarshal(payload, failure)
-		if err != nil {
-			return err
-		}
-		return failure
-	} else {
-		return ERR_UNRECOGNIZED_RETURN
-	}
+	d.wallet = int(number)
+	return nil
 }
 
-func ScanWallet(d *Device) ([]byte, error) {
-	err := hid.Write(d.dev, hid.COMMAND_SCAN_WALLET)
-	if err != nil {
-		return nil, err
-	}
-
-	res := new(bytes.Buffer)
-	err = hid.Read(d.dev, res)
-	if err != nil {
-		return nil, err
-	}
-
-	_, _, payload := hid.ParseResponse(res)
-
+func ScanWallet(ctx context.Context, d *Device) ([]byte, error) {
 	xpub := &models.CurrentWalletXPUB{}
 
-	err = proto.Unmarshal(payload, xpub)
+	err := callExpect(ctx, d, hid.COMMAND_SCAN_WALLET, nil, hid.RESPONSE_XPUB, xpub)
 	if err != nil {
 		return nil, err
 	}
@@ -124,11 +112,21 @@ func ScanWallet(d *Device) ([]byte, error) {
 	return xpub.Xpub, nil
 }
 
+// DisplayAddressAsQR shows receive address index of the loaded wallet
+// on the device as a QR code, so it can be checked against the address
+// derived on the host. It returns once the device is done showing it.
+func DisplayAddressAsQR(ctx context.Context, d *Device, index uint32) error {
+	if d.wallet < 0 {
+		return ERR_NO_WALLET_LOADED
+	}
+	return callSuccess(ctx, d, hid.PREFIX_DISPLAY_ADDRESS_QR, &models.DisplayAddressAsQR{Index: index})
+}
+
 func makeAddressHandle(ch uint32, chainIndex uint32) []byte {
 	b := []byte{10}
 	chain := make([]byte, 4)
 	binary.LittleEndian.PutUint32(chain, ch)
-	chain = bytes.TrimRight(chain, string(0))
+	chain = bytes.TrimRight(chain, string([]byte{0x00}))
 	if len(chain) == 0 {
 		chain = []byte{0}
 	}
@@ -136,7 +134,7 @@ func makeAddressHandle(ch uint32, chainIndex uint32) []byte {
 	rootAndChain = append(rootAndChain, 24)
 	index := make([]byte, 4)
 	binary.LittleEndian.PutUint32(index, chainIndex)
-	index = bytes.TrimRight(index, string(0))
+	index = bytes.TrimRight(index, string([]byte{0x00}))
 	if len(index) == 0 {
 		index = []byte{0}
 	}
@@ -162,7 +160,7 @@ func getMessageSize(msg []byte) []byte {
 	}
 }
 
-func SignMessage(d *Device, address *wallet.Address, message []byte) ([]byte, error) {
+func SignMessage(ctx context.Context, d *Device, address *wallet.Address, message []byte) ([]byte, error) {
 
 	message = messageTrim.ReplaceAll(message, []byte{})
 
@@ -179,66 +177,38 @@ func SignMessage(d *Device, address *wallet.Address, message []byte) ([]byte, er
 		Message: message,
 	}
 
-	mBytes, err := proto.Marshal(m)
-	if err != nil {
-		return nil, err
-	}
-
-	err = hid.WriteVariable(d.dev, hid.PREFIX_SIGN_MESSAGE, mBytes)
-	if err != nil {
-		return nil, err
-	}
+	sig := &models.SignatureComplete{}
 
-	res := new(bytes.Buffer)
-	err = hid.Read(d.dev, res)
+	err := callExpect(ctx, d, hid.PREFIX_SIGN_MESSAGE, m, hid.RESPONSE_MESSAGE_SIGNATURE, sig)
 	if err != nil {
 		return nil, err
 	}
 
-	resCmd, _, payload := hid.ParseResponse(res)
-
-	// check if the command is "please ACK", if so, send the ack,
-	// read, and then parse *that* response
-	if resCmd != hid.RESPONSE_PLEASE_ACK {
-		return nil, ERR_UNRECOGNIZED_RETURN
-	}
-
-	logger.Debug("sending ACK")
-
-	err = hid.Write(d.dev, hid.COMMAND_ACK)
-	if err != nil {
-		return nil, err
-	}
+	return processSignedMessage2(address, message, sig.Signature)
+}
 
-	res = new(bytes.Buffer)
-	err = hid.Read(d.dev, res)
-	if err != nil {
-		return nil, err
+// expectSuccess turns a success or error response into nil or the
+// Failure it carries
+func expectSuccess(res *hid.Frame) error {
+	if res.Command == hid.RESPONSE_SUCCESS {
+		return nil
+	} else if res.Command == hid.RESPONSE_ERROR {
+		return parseFailure(res.Payload)
+	} else {
+		return ERR_UNRECOGNIZED_RETURN
 	}
+}
 
-	resCmd, _, payload = hid.ParseResponse(res)
-
-	sig := &models.SignatureComplete{}
+// parseFailure turns the payload of an error response into the
+// Failure it carries
+func parseFailure(payload []byte) error {
+	failure := &models.Failure{}
 
-	err = proto.Unmarshal(payload, sig)
+	err := proto.Unmarshal(payload, failure)
 	if err != nil {
-		return nil, err
-	}
-
-	if resCmd == hid.RESPONSE_MESSAGE_SIGNATURE {
-		return processSignedMessage2(address, message, sig.Signature)
-	} else if resCmd == hid.RESPONSE_ERROR {
-		failure := &models.Failure{}
-
-		err = proto.Unmarshal(payload, failure)
-		if err != nil {
-			return nil, err
-		}
-		return nil, failure
-	} else {
-		return nil, ERR_UNRECOGNIZED_RETURN
+		return err
 	}
-
+	return failure
 }
 
 func doubleSha(b []byte) []byte {
@@ -266,7 +236,9 @@ func processSignedMessage2(address *wallet.Address, message, derSig []byte) ([]b
 	signature = append(signature, r...)
 	signature = append(signature, s...)
 
-	for nV := 31; nV < 34; nV++ {
+	// 31 to 34 mark a signature by a compressed key, which is what
+	// wallet addresses are made from
+	for nV := 31; nV <= 34; nV++ {
 		signature[0] = byte(nV)
 		b64len := base64.StdEncoding.EncodedLen(len(signature))
 		b64 := make([]byte, b64len)
@@ -274,7 +246,9 @@ func processSignedMessage2(address *wallet.Address, message, derSig []byte) ([]b
 		logger.Debug(nV, string(b64))
 		// Validate the signature - this just shows that it was valid at all.
 		// we will compare it with the key next.
-		expectedMessageHash := doubleSha(append(messagePrefix, message...))
+		// message already holds the prefix and both lengths, which
+		// is what the device hashes
+		expectedMessageHash := doubleSha(message)
 
 		pk, wasCompressed, err := btcec.RecoverCompact(btcec.S256(), signature, expectedMessageHash)
 		logger.Debug("wasCompressed", wasCompressed)
@@ -283,9 +257,7 @@ func processSignedMessage2(address *wallet.Address, message, derSig []byte) ([]b
 			continue
 		}
 
-		var serializedPK []byte
-		serializedPK = pk.SerializeUncompressed()
-		addr, err := btcutil.NewAddressPubKey(serializedPK, &chaincfg.MainNetParams)
+		addr, err := btcutil.NewAddressPubKey(pk.SerializeCompressed(), &chaincfg.MainNetParams)
 		if err != nil {
 			logger.Debug("nV", nV, err)
 			continue
@@ -0,0 +1,70 @@
+package bitlox_test
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"bitlox"
+	"bitlox/simulator"
+
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+)
+
+// openSimulator opens a session with a simulator holding one wallet
+// per name, closed when the test ends
+func openSimulator(t *testing.T, names ...string) (*simulator.Simulator, *bitlox.Device) {
+	sim, err := simulator.New(simulator.TestSeed, names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := bitlox.Open(context.Background(), sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return sim, d
+}
+
+// TestSignMessage signs through the simulator and checks the result
+// the way any Bitcoin message verifier would
+func TestSignMessage(t *testing.T) {
+	d, w := scannedWallet(t)
+	defer d.Close()
+	a, err := w.ReceiveAddress(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64, err := bitlox.SignMessage(context.Background(), d, a, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg bytes.Buffer
+	wire.WriteVarString(&msg, 0, "Bitcoin Signed Message:\n")
+	wire.WriteVarString(&msg, 0, "hello")
+	key, compressed, err := btcec.RecoverCompact(btcec.S256(), sig, chainhash.DoubleHashB(msg.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compressed {
+		t.Fatalf("signature is not marked as by a compressed key")
+	}
+	addr, err := btcutil.NewAddressPubKey(key.SerializeCompressed(), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.EncodeAddress() != a.String() {
+		t.Fatalf("signed by %s, expected %s", addr.EncodeAddress(), a)
+	}
+}
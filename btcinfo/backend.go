@@ -0,0 +1,68 @@
+package btcinfo
+
+import (
+	"github.com/btcsuite/btcd/wire"
+
+	"errors"
+	"fmt"
+)
+
+// Backend is where address balances and unspent outputs come from and
+// where transactions are sent to the network
+type Backend interface {
+	GetAddress(address string) (*Address, error)
+	GetUnspent(address string) ([]*Output, error)
+	// GetTransaction returns the transaction with the given hash, in
+	// the byte order Output.HashStr uses
+	GetTransaction(hash string) (*wire.MsgTx, error)
+	// Broadcast sends a signed raw transaction and returns its hash
+	Broadcast(rawTx []byte) (string, error)
+}
+
+var ERR_UNKNOWN_TRANSACTION = errors.New("Transaction not found")
+
+// BroadcastError is returned when a backend refuses a transaction
+type BroadcastError struct {
+	Status  int
+	Message string
+}
+
+func (e *BroadcastError) Error() string {
+	return fmt.Sprintf("Broadcast failed (%d): %s", e.Status, e.Message)
+}
+
+// TransactionError is returned when a backend cannot give the raw
+// transaction asked for
+type TransactionError struct {
+	Hash    string
+	Status  int
+	Message string
+}
+
+func (e *TransactionError) Error() string {
+	return fmt.Sprintf("Fetching transaction %s failed (%d): %s", e.Hash, e.Status, e.Message)
+}
+
+var backend Backend = &Toshi{}
+
+// SetBackend replaces the backend used by GetAddress, GetUnspent,
+// GetTransaction and Broadcast, toshi.io by default
+func SetBackend(b Backend) {
+	backend = b
+}
+
+func GetAddress(pubkey string) (*Address, error) {
+	return backend.GetAddress(pubkey)
+}
+
+func GetUnspent(pubkey string) ([]*Output, error) {
+	return backend.GetUnspent(pubkey)
+}
+
+func GetTransaction(hash string) (*wire.MsgTx, error) {
+	return backend.GetTransaction(hash)
+}
+
+func Broadcast(rawTx []byte) (string, error) {
+	return backend.Broadcast(rawTx)
+}
@@ -0,0 +1,120 @@
+package btcinfo
+
+import (
+	"github.com/btcsuite/btcd/wire"
+
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// Mock is a Backend kept in memory, for tests and for running against
+// the simulator. Addresses it does not know have received nothing.
+// Broadcast checks the transaction parses and records it.
+type Mock struct {
+	Addresses map[string]*Address  `json:"addresses"`
+	Unspent   map[string][]*Output `json:"unspent"`
+	// raw transactions in hex, keyed by hash
+	Transactions map[string]string `json:"transactions"`
+	// BroadcastErr, if set, is returned by Broadcast instead
+	BroadcastErr error `json:"-"`
+
+	mu        sync.Mutex
+	broadcast [][]byte
+}
+
+func NewMock() *Mock {
+	return &Mock{
+		Addresses:    make(map[string]*Address),
+		Unspent:      make(map[string][]*Output),
+		Transactions: make(map[string]string),
+	}
+}
+
+// LoadMock reads a Mock from a JSON file with "addresses" and
+// "unspent" objects keyed by address, in the same form as the toshi
+// API gives them, and a "transactions" object of raw transactions in
+// hex keyed by hash
+func LoadMock(file string) (*Mock, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMock()
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Mock) GetAddress(address string) (*Address, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.Addresses[address]; ok {
+		return a, nil
+	}
+	return &Address{}, nil
+}
+
+func (m *Mock) GetUnspent(address string) ([]*Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Unspent[address], nil
+}
+
+func (m *Mock) GetTransaction(hash string) (*wire.MsgTx, error) {
+	m.mu.Lock()
+	raw, ok := m.Transactions[hash]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ERR_UNKNOWN_TRANSACTION
+	}
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	if tx.TxHash().String() != hash {
+		return nil, fmt.Errorf("Mock transaction %s has hash %s", hash, tx.TxHash())
+	}
+	return tx, nil
+}
+
+// AddTransaction makes tx known to GetTransaction
+func (m *Mock) AddTransaction(tx *wire.MsgTx) error {
+	b := &bytes.Buffer{}
+	if err := tx.Serialize(b); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Transactions[tx.TxHash().String()] = hex.EncodeToString(b.Bytes())
+	return nil
+}
+
+func (m *Mock) Broadcast(rawTx []byte) (string, error) {
+	if m.BroadcastErr != nil {
+		return "", m.BroadcastErr
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return "", &BroadcastError{Status: 400, Message: err.Error()}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.broadcast = append(m.broadcast, append([]byte{}, rawTx...))
+	return tx.TxHash().String(), nil
+}
+
+// Broadcasts are the raw transactions broadcast so far
+func (m *Mock) Broadcasts() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte{}, m.broadcast...)
+}
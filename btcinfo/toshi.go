@@ -1,10 +1,14 @@
 package btcinfo
 
 import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -14,6 +18,9 @@ import (
 )
 
 const TOSHI_URL = "https://bitcoin.toshi.io/api/v0/addresses"
+const TOSHI_TRANSACTIONS_URL = "https://bitcoin.toshi.io/api/v0/transactions"
+
+var ERR_NO_RAW_TRANSACTIONS = errors.New("The toshi backend cannot fetch raw transactions")
 
 var UnitBTC = btcutil.AmountBTC
 var UnitBits = btcutil.AmountMicroBTC
@@ -104,7 +111,18 @@ func doReq(path string, resItem interface{}) error {
 	return nil
 }
 
-func GetAddress(pubkey string) (*Address, error) {
+// Toshi is the Backend for the toshi.io API
+type Toshi struct {
+	// BroadcastURL, when set, replaces TOSHI_TRANSACTIONS_URL for
+	// Broadcast. The transaction is POSTed to it as hex and the answer
+	// is the hash, either in toshi's JSON or as plain text, which is
+	// how Esplora servers such as https://blockstream.info/api/tx
+	// reply. Esplora also serves a transaction in raw form under the
+	// same URL, which is where GetTransaction fetches them from.
+	BroadcastURL string
+}
+
+func (t *Toshi) GetAddress(pubkey string) (*Address, error) {
 	addr := &Address{}
 	err := doReq(TOSHI_URL+"/"+pubkey, addr)
 	if err != nil {
@@ -113,7 +131,7 @@ func GetAddress(pubkey string) (*Address, error) {
 	return addr, nil
 }
 
-func GetUnspent(pubkey string) ([]*Output, error) {
+func (t *Toshi) GetUnspent(pubkey string) ([]*Output, error) {
 	unspent := make([]*Output, 0)
 	err := doReq(TOSHI_URL+"/"+pubkey+"/unspent_outputs", &unspent)
 	if err != nil {
@@ -124,3 +142,85 @@ func GetUnspent(pubkey string) ([]*Output, error) {
 	}
 	return unspent, nil
 }
+
+// GetTransaction fetches the transaction from <BroadcastURL>/<hash>/hex
+// the way Esplora serves it. The toshi API has no documented way to
+// fetch a transaction in raw form, so without a BroadcastURL it gives
+// ERR_NO_RAW_TRANSACTIONS.
+func (t *Toshi) GetTransaction(hash string) (*wire.MsgTx, error) {
+	if t.BroadcastURL == "" {
+		return nil, ERR_NO_RAW_TRANSACTIONS
+	}
+	resp, err := http.Get(strings.TrimRight(t.BroadcastURL, "/") + "/" + hash + "/hex")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ERR_UNKNOWN_TRANSACTION
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &TransactionError{Hash: hash, Status: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	if tx.TxHash().String() != hash {
+		return nil, &TransactionError{Hash: hash, Status: resp.StatusCode, Message: "got transaction " + tx.TxHash().String()}
+	}
+	return tx, nil
+}
+
+type broadcastResult struct {
+	Hash  string `json:"hash"`
+	Error string `json:"error"`
+}
+
+func (t *Toshi) Broadcast(rawTx []byte) (string, error) {
+	method, url := http.MethodPut, TOSHI_TRANSACTIONS_URL
+	if t.BroadcastURL != "" {
+		method, url = http.MethodPost, t.BroadcastURL
+	}
+	req, err := http.NewRequest(method, url, strings.NewReader(hex.EncodeToString(rawTx)))
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	res := &broadcastResult{}
+	if err := json.Unmarshal(body, res); err != nil {
+		hash := strings.TrimSpace(string(body))
+		if resp.StatusCode == http.StatusOK && isHash(hash) {
+			return hash, nil
+		}
+		return "", &BroadcastError{Status: resp.StatusCode, Message: string(body)}
+	}
+	if resp.StatusCode != http.StatusOK || res.Hash == "" {
+		return "", &BroadcastError{Status: resp.StatusCode, Message: res.Error}
+	}
+	return res.Hash, nil
+}
+
+// isHash reports whether s is a transaction hash in hex
+func isHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == chainhash.HashSize
+}
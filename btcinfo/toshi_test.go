@@ -0,0 +1,96 @@
+package btcinfo
+
+import (
+	"github.com/btcsuite/btcd/wire"
+
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestToshiBroadcastURL(t *testing.T) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	var raw bytes.Buffer
+	if err := tx.Serialize(&raw); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		got = string(body)
+		w.Write([]byte(tx.TxHash().String()))
+	}))
+	defer server.Close()
+
+	hash, err := (&Toshi{BroadcastURL: server.URL}).Broadcast(raw.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != hex.EncodeToString(raw.Bytes()) {
+		t.Fatalf("posted %q", got)
+	}
+	if hash != tx.TxHash().String() {
+		t.Fatalf("got hash %s, expected %s", hash, tx.TxHash())
+	}
+}
+
+func TestToshiBroadcastRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad-txns-inputs-missingorspent", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := (&Toshi{BroadcastURL: server.URL}).Broadcast([]byte{0x01})
+	berr, ok := err.(*BroadcastError)
+	if !ok || berr.Status != http.StatusBadRequest {
+		t.Fatalf("got %v, expected a BroadcastError", err)
+	}
+}
+
+func TestToshiGetTransaction(t *testing.T) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	var raw bytes.Buffer
+	if err := tx.Serialize(&raw); err != nil {
+		t.Fatal(err)
+	}
+	hash := tx.TxHash().String()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/tx/"+hash+"/hex" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(hex.EncodeToString(raw.Bytes())))
+	}))
+	defer server.Close()
+
+	toshi := &Toshi{BroadcastURL: server.URL + "/api/tx"}
+	got, err := toshi.GetTransaction(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TxHash() != tx.TxHash() {
+		t.Fatalf("got transaction %s, expected %s", got.TxHash(), hash)
+	}
+
+	unknown := strings.Repeat("00", 32)
+	if _, err := toshi.GetTransaction(unknown); err != ERR_UNKNOWN_TRANSACTION {
+		t.Fatalf("expected ERR_UNKNOWN_TRANSACTION, got %v", err)
+	}
+	if _, err := (&Toshi{}).GetTransaction(hash); err != ERR_NO_RAW_TRANSACTIONS {
+		t.Fatalf("expected ERR_NO_RAW_TRANSACTIONS, got %v", err)
+	}
+}
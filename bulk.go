@@ -0,0 +1,75 @@
+package bitlox
+
+import (
+	"bitlox/hid"
+	models "bitlox/proto"
+
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+)
+
+// BulkMismatchError is returned by WriteBulk when the bulk data read
+// back from the device is not what was written
+type BulkMismatchError struct {
+	WroteLength int
+	ReadLength  int
+	WroteHash   [sha256.Size]byte
+	ReadHash    [sha256.Size]byte
+}
+
+func (e *BulkMismatchError) Error() string {
+	return fmt.Sprintf("Bulk data read back does not match: wrote %d bytes sha256 %x, read %d bytes sha256 %x",
+		e.WroteLength, e.WroteHash, e.ReadLength, e.ReadHash)
+}
+
+// BackupWallet has the device back up the loaded wallet. device picks
+// the backup destination as numbered by the firmware.
+func BackupWallet(ctx context.Context, d *Device, encrypted bool, device uint32) error {
+	if d.wallet < 0 {
+		return ERR_NO_WALLET_LOADED
+	}
+	return callSuccess(ctx, d, hid.PREFIX_BACKUP_WALLET, &models.BackupWallet{IsEncrypted: encrypted, Device: device})
+}
+
+// GetBulk reads the device's bulk storage area
+func GetBulk(ctx context.Context, d *Device) ([]byte, error) {
+	bulk := &models.Bulk{}
+
+	err := callExpect(ctx, d, hid.COMMAND_GET_BULK, nil, hid.RESPONSE_BULK, bulk)
+	if err != nil {
+		return nil, err
+	}
+
+	return bulk.Bulk, nil
+}
+
+// SetBulk replaces the contents of the bulk storage area. The device
+// asks for a button press first.
+func SetBulk(ctx context.Context, d *Device, data []byte) error {
+	return callSuccess(ctx, d, hid.PREFIX_SET_BULK, &models.SetBulk{Bulk: data})
+}
+
+// WriteBulk is SetBulk followed by reading the data back, returning a
+// *BulkMismatchError if its length or hash differ from what was written
+func WriteBulk(ctx context.Context, d *Device, data []byte) error {
+	if err := SetBulk(ctx, d, data); err != nil {
+		return err
+	}
+
+	read, err := GetBulk(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(read, data) {
+		return &BulkMismatchError{
+			WroteLength: len(data),
+			ReadLength:  len(read),
+			WroteHash:   sha256.Sum256(data),
+			ReadHash:    sha256.Sum256(read),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package bitlox_test
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"bitlox"
+	"bitlox/hid"
+	"bitlox/hid/hidtest"
+	models "bitlox/proto"
+
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWriteBulk(t *testing.T) {
+	_, d := openSimulator(t)
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("bulk data "), 100)
+	if err := bitlox.WriteBulk(ctx, d, data); err != nil {
+		t.Fatal(err)
+	}
+	read, err := bitlox.GetBulk(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("read back %d bytes, wrote %d", len(read), len(data))
+	}
+}
+
+// TestWriteBulkMismatch has the device read back something other than
+// what was written
+func TestWriteBulkMismatch(t *testing.T) {
+	success, err := proto.Marshal(&models.Success{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulk, err := proto.Marshal(&models.Bulk{Bulk: []byte("truncated")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := hidtest.EncodeResponse(hid.RESPONSE_SUCCESS, success)
+	stream = append(stream, hidtest.EncodeResponse(hid.RESPONSE_BULK, bulk)...)
+	d := bitlox.NewDevice(hidtest.NewLoopback(stream, hid.CHUNK_SIZE))
+
+	err = bitlox.WriteBulk(context.Background(), d, []byte("truncated data"))
+	var mismatch *bitlox.BulkMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a BulkMismatchError, got %v", err)
+	}
+	if mismatch.WroteLength != 14 || mismatch.ReadLength != 9 {
+		t.Errorf("wrote %d, read %d", mismatch.WroteLength, mismatch.ReadLength)
+	}
+}
+
+func TestBackupWallet(t *testing.T) {
+	_, d := openSimulator(t, "Test wallet")
+	ctx := context.Background()
+	if err := bitlox.BackupWallet(ctx, d, true, 0); err != bitlox.ERR_NO_WALLET_LOADED {
+		t.Fatalf("expected ERR_NO_WALLET_LOADED, got %v", err)
+	}
+	if err := bitlox.LoadWallet(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := bitlox.BackupWallet(ctx, d, true, 0); err != nil {
+		t.Fatal(err)
+	}
+}
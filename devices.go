@@ -0,0 +1,106 @@
+package bitlox
+
+import (
+	"bitlox/hid"
+	"bitlox/logger"
+
+	"context"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ERR_DEVICE_NOT_FOUND = errors.New("No attached device matches")
+
+// DeviceInfo identifies one attached BitLox
+type DeviceInfo struct {
+	Path   string
+	Serial string
+	UUID   []byte
+}
+
+func (i *DeviceInfo) UUIDString() string {
+	return hex.EncodeToString(i.UUID)
+}
+
+// matches reports whether selector names this device, either by its
+// path, serial number or (a prefix of) its UUID
+func (i *DeviceInfo) matches(selector string) bool {
+	if selector == i.Path || (i.Serial != "" && selector == i.Serial) {
+		return true
+	}
+	return len(i.UUID) > 0 && strings.HasPrefix(i.UUIDString(), strings.ToLower(selector))
+}
+
+// ListDevices returns every attached BitLox. Each one is opened
+// briefly to ask for its UUID.
+func ListDevices(ctx context.Context) ([]*DeviceInfo, error) {
+	infoList, err := hid.Enumerate(VENDOR_ID, PRODUCT_ID)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*DeviceInfo, 0, len(infoList))
+	for _, info := range infoList {
+		device := &DeviceInfo{Path: info.Path, Serial: info.Serial}
+		t, err := hid.Open(info.Path)
+		if err != nil {
+			logger.Debug("Error opening device", info.Path, err)
+		} else {
+			d := NewDevice(t)
+			device.UUID, err = GetDeviceUUID(ctx, d)
+			if err != nil {
+				logger.Debug("Error getting device uuid", info.Path, err)
+			}
+			d.Close()
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// OpenDevice opens the attached BitLox named by selector, which is an
+// index into ListDevices, a path, a serial number or a UUID prefix,
+// and starts a session on it. An empty selector opens the first
+// device.
+func OpenDevice(ctx context.Context, selector string) (*Device, error) {
+	t, err := OpenTransport(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	return Open(ctx, t)
+}
+
+// OpenTransport is OpenDevice without the Device wrapper, for callers
+// that want to layer their own transport (a Recorder, say) on top
+func OpenTransport(ctx context.Context, selector string) (hid.Transport, error) {
+	if selector == "" {
+		return hid.GetDevice(VENDOR_ID, PRODUCT_ID)
+	}
+	devices, err := ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	device, err := SelectDevice(devices, selector)
+	if err != nil {
+		return nil, err
+	}
+	return hid.Open(device.Path)
+}
+
+// SelectDevice picks the device named by selector out of devices, as
+// OpenDevice does
+func SelectDevice(devices []*DeviceInfo, selector string) (*DeviceInfo, error) {
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 0 || index >= len(devices) {
+			return nil, ERR_DEVICE_NOT_FOUND
+		}
+		return devices[index], nil
+	}
+	for _, device := range devices {
+		if device.matches(selector) {
+			return device, nil
+		}
+	}
+	return nil, ERR_DEVICE_NOT_FOUND
+}
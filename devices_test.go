@@ -0,0 +1,57 @@
+package bitlox_test
+
+import (
+	"bitlox"
+	"bitlox/simulator"
+
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// simulatedDevices describes two simulators with different seeds the
+// way ListDevices would, asking each for its UUID
+func simulatedDevices(t *testing.T) []*bitlox.DeviceInfo {
+	var devices []*bitlox.DeviceInfo
+	for i, serial := range []string{"", "BLX0002"} {
+		seed := append([]byte{}, simulator.TestSeed...)
+		seed[0] = byte(i)
+		sim, err := simulator.New(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := bitlox.NewDevice(sim)
+		uuid, err := bitlox.GetDeviceUUID(context.Background(), d)
+		d.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(uuid, sim.UUID()) {
+			t.Fatalf("device uuid %x, simulator has %x", uuid, sim.UUID())
+		}
+		devices = append(devices, &bitlox.DeviceInfo{Path: "sim" + string('0'+rune(i)), Serial: serial, UUID: uuid})
+	}
+	return devices
+}
+
+func TestSelectDevice(t *testing.T) {
+	devices := simulatedDevices(t)
+	second := devices[1]
+	uuid := second.UUIDString()
+
+	for _, selector := range []string{"1", "sim1", "BLX0002", uuid, uuid[0:8], strings.ToUpper(uuid[0:8])} {
+		device, err := bitlox.SelectDevice(devices, selector)
+		if err != nil {
+			t.Fatalf("%q: %s", selector, err)
+		}
+		if device != second {
+			t.Fatalf("%q selected %s, expected %s", selector, device.Path, second.Path)
+		}
+	}
+	for _, selector := range []string{"2", "-1", "BLX0003", "sim2", "zz"} {
+		if _, err := bitlox.SelectDevice(devices, selector); err != bitlox.ERR_DEVICE_NOT_FOUND {
+			t.Fatalf("%q: expected ERR_DEVICE_NOT_FOUND, got %v", selector, err)
+		}
+	}
+}
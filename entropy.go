@@ -0,0 +1,70 @@
+package bitlox
+
+import (
+	"bitlox/hid"
+	models "bitlox/proto"
+
+	"context"
+	"errors"
+	"fmt"
+)
+
+// the most entropy asked for in one GetEntropy, larger amounts are
+// read in chunks by EntropyReader
+const MAX_ENTROPY_REQUEST = 1024
+
+var ERR_ENTROPY_REQUEST = errors.New(fmt.Sprintf("Entropy requests must be between 1 and %d bytes", MAX_ENTROPY_REQUEST))
+var ERR_ENTROPY_SHORT = errors.New("Device returned less entropy than requested")
+
+// GetEntropy returns n bytes from the device's hardware random number
+// generator, n being at most MAX_ENTROPY_REQUEST
+func GetEntropy(ctx context.Context, d *Device, n int) ([]byte, error) {
+	if n < 1 || n > MAX_ENTROPY_REQUEST {
+		return nil, ERR_ENTROPY_REQUEST
+	}
+
+	entropy := &models.Entropy{}
+
+	err := callExpect(ctx, d, hid.PREFIX_GET_ENTROPY, &models.GetEntropy{NumberOfBytes: uint32(n)}, hid.RESPONSE_ENTROPY, entropy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entropy.Entropy) != n {
+		return nil, ERR_ENTROPY_SHORT
+	}
+	return entropy.Entropy, nil
+}
+
+// EntropyReader is an io.Reader of device entropy, fetched with
+// GetEntropy in chunks of up to MAX_ENTROPY_REQUEST bytes
+type EntropyReader struct {
+	ctx context.Context
+	d   *Device
+	buf []byte
+}
+
+// NewEntropyReader reads entropy from d until ctx is done
+func NewEntropyReader(ctx context.Context, d *Device) *EntropyReader {
+	return &EntropyReader{ctx: ctx, d: d}
+}
+
+func (r *EntropyReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(r.buf) == 0 {
+		n := len(p)
+		if n > MAX_ENTROPY_REQUEST {
+			n = MAX_ENTROPY_REQUEST
+		}
+		entropy, err := GetEntropy(r.ctx, r.d, n)
+		if err != nil {
+			return 0, err
+		}
+		r.buf = entropy
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,99 @@
+package bitlox_test
+
+import (
+	"bitlox"
+
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+func TestGetEntropyLength(t *testing.T) {
+	_, d := openSimulator(t)
+	ctx := context.Background()
+	for _, n := range []int{1, 32, bitlox.MAX_ENTROPY_REQUEST} {
+		entropy, err := bitlox.GetEntropy(ctx, d, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entropy) != n {
+			t.Errorf("asked for %d bytes, got %d", n, len(entropy))
+		}
+	}
+	for _, n := range []int{0, bitlox.MAX_ENTROPY_REQUEST + 1} {
+		if _, err := bitlox.GetEntropy(ctx, d, n); err != bitlox.ERR_ENTROPY_REQUEST {
+			t.Errorf("%d bytes: expected ERR_ENTROPY_REQUEST, got %v", n, err)
+		}
+	}
+}
+
+// readEntropy reads n bytes through an EntropyReader, which takes
+// several requests for more than MAX_ENTROPY_REQUEST
+func readEntropy(t *testing.T, d *bitlox.Device, n int) []byte {
+	entropy := make([]byte, n)
+	if _, err := io.ReadFull(bitlox.NewEntropyReader(context.Background(), d), entropy); err != nil {
+		t.Fatal(err)
+	}
+	return entropy
+}
+
+func TestEntropyReaderNotConstant(t *testing.T) {
+	_, d := openSimulator(t)
+	first := readEntropy(t, d, 3*bitlox.MAX_ENTROPY_REQUEST+17)
+	second := readEntropy(t, d, len(first))
+	if bytes.Equal(first, second) {
+		t.Fatalf("two reads gave the same entropy")
+	}
+	if bytes.Count(first, first[0:1]) == len(first) {
+		t.Fatalf("entropy is one repeated byte")
+	}
+	// chunks of one read must not repeat either
+	chunk := bitlox.MAX_ENTROPY_REQUEST
+	if bytes.Equal(first[0:chunk], first[chunk:2*chunk]) {
+		t.Fatalf("entropy repeats from one request to the next")
+	}
+}
+
+// The statistical checks only catch a badly broken source, their
+// bounds are loose enough that a good one fails about once in tens of
+// thousands of runs.
+const ENTROPY_SAMPLE = 16 * 1024
+
+// TestEntropyMonobit checks that ones and zeros are about as common,
+// within 4 standard deviations
+func TestEntropyMonobit(t *testing.T) {
+	_, d := openSimulator(t)
+	entropy := readEntropy(t, d, ENTROPY_SAMPLE)
+	ones := 0
+	for _, b := range entropy {
+		ones += bits.OnesCount8(b)
+	}
+	n := float64(8 * len(entropy))
+	z := math.Abs(2*float64(ones)-n) / math.Sqrt(n)
+	if z > 4 {
+		t.Fatalf("%d ones in %.0f bits, %.1f standard deviations out", ones, n, z)
+	}
+}
+
+// TestEntropyChiSquare checks that every byte value turns up about as
+// often. With 255 degrees of freedom the statistic averages 255 with a
+// standard deviation near 22.6.
+func TestEntropyChiSquare(t *testing.T) {
+	_, d := openSimulator(t)
+	entropy := readEntropy(t, d, ENTROPY_SAMPLE)
+	counts := make([]int, 256)
+	for _, b := range entropy {
+		counts[b]++
+	}
+	expected := float64(len(entropy)) / 256
+	chi := 0.0
+	for _, c := range counts {
+		chi += (float64(c) - expected) * (float64(c) - expected) / expected
+	}
+	if chi < 165 || chi > 345 {
+		t.Fatalf("chi-square %.1f is outside 165 to 345", chi)
+	}
+}
@@ -6,13 +6,59 @@ var BITLOX_TERMINATOR = []byte{0x7e, 0x7e}
 
 var COMMAND_ACK = []byte{0x00, 0x51, 0x00, 0x00, 0x00, 0x00}
 
+// answers to the interjections the device sends before responding to
+// some commands
+var COMMAND_BUTTON_CANCEL = []byte{0x00, 0x52, 0x00, 0x00, 0x00, 0x00}
+var PREFIX_PIN_ACK = []byte{0x00, 0x54}
+var COMMAND_PIN_CANCEL = []byte{0x00, 0x55, 0x00, 0x00, 0x00, 0x00}
+var PREFIX_OTP_ACK = []byte{0x00, 0x57}
+var COMMAND_OTP_CANCEL = []byte{0x00, 0x58, 0x00, 0x00, 0x00, 0x00}
+
 var COMMAND_LIST_WALLETS = []byte{0x00, 0x10, 0x00, 0x00, 0x00, 0x00}
 var COMMAND_SCAN_WALLET = []byte{0x00, 0x61, 0x00, 0x00, 0x00, 0x00}
 
+var COMMAND_GET_DEVICE_UUID = []byte{0x00, 0x13, 0x00, 0x00, 0x00, 0x00}
+
+var COMMAND_GET_MASTER_PUBLIC_KEY = []byte{0x00, 0x15, 0x00, 0x00, 0x00, 0x00}
+
+// ping with the greeting "Hello"
+var COMMAND_PING = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x0A, 0x05, 0x48, 0x65, 0x6C, 0x6C, 0x6F}
+var PING_GREETING = "Hello"
+
 var PREFIX_LOAD_WALLET = []byte{0x00, 0x0B, 0x00, 0x00, 0x00, 0x02, 0x08}
 
 var PREFIX_SIGN_MESSAGE = []byte{0x00, 0x70}
 
+var PREFIX_SIGN_TRANSACTION = []byte{0x00, 0x65}
+
+var PREFIX_DISPLAY_ADDRESS_QR = []byte{0x00, 0x80}
+
+var PREFIX_INITIALIZE = []byte{0x00, 0x17}
+
+var PREFIX_NEW_WALLET = []byte{0x00, 0x04}
+
+var PREFIX_RESTORE_WALLET = []byte{0x00, 0x18}
+
+var PREFIX_DELETE_WALLET = []byte{0x00, 0x16, 0x00, 0x00, 0x00, 0x02, 0x08}
+
+var PREFIX_RENAME_WALLET = []byte{0x00, 0x0F}
+
+// device administration, the language menu and PIN change happen on
+// the device itself
+var COMMAND_RESET_LANG = []byte{0x00, 0x1B, 0x00, 0x00, 0x00, 0x00}
+var COMMAND_RESET_PIN = []byte{0x00, 0x1C, 0x00, 0x00, 0x00, 0x00}
+
+var PREFIX_FORMAT = []byte{0x00, 0x0D}
+var PREFIX_CHANGE_ENCRYPTION_KEY = []byte{0x00, 0x0E}
+var PREFIX_CHANGE_DEVICE_NAME = []byte{0x00, 0x1A}
+
+var PREFIX_GET_ENTROPY = []byte{0x00, 0x14}
+
+var PREFIX_BACKUP_WALLET = []byte{0x00, 0x12}
+
+var COMMAND_GET_BULK = []byte{0x00, 0x1D, 0x00, 0x00, 0x00, 0x00}
+var PREFIX_SET_BULK = []byte{0x00, 0x1E}
+
 var bitloxCommands = map[string][]byte{
 	"magic":      []byte{0x23, 0x23},
 	"terminator": []byte{0x7e, 0x7e},
@@ -43,5 +89,16 @@ var bitloxCommands = map[string][]byte{
 
 var RESPONSE_SUCCESS byte = 0x34
 var RESPONSE_ERROR byte = 0x35
+var RESPONSE_FEATURES byte = 0x30
+var RESPONSE_PING byte = 0x31
+var RESPONSE_WALLETS byte = 0x32
+var RESPONSE_DEVICE_UUID byte = 0x33
+var RESPONSE_ENTROPY byte = 0x36
+var RESPONSE_MASTER_PUBLIC_KEY byte = 0x37
+var RESPONSE_BULK byte = 0x38
 var RESPONSE_PLEASE_ACK byte = 0x50
+var RESPONSE_PIN_REQUEST byte = 0x53
+var RESPONSE_OTP_REQUEST byte = 0x56
+var RESPONSE_XPUB byte = 0x62
+var RESPONSE_TX_SIGNATURES byte = 0x66
 var RESPONSE_MESSAGE_SIGNATURE byte = 0x71
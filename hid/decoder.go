@@ -0,0 +1,225 @@
+package hid
+
+import (
+	"bitlox/logger"
+
+	"errors"
+	"fmt"
+)
+
+// DEFAULT_MAX_FRAME_PAYLOAD bounds the payload a Decoder accepts unless
+// told otherwise. Nothing the device sends comes close to it.
+const DEFAULT_MAX_FRAME_PAYLOAD = 1 << 20
+
+var ERR_FRAME_TRUNCATED = errors.New("Frame truncated")
+var ERR_FRAME_TOO_LARGE = errors.New("Frame payload too large")
+var ERR_FRAME_BAD_COMMAND = errors.New("Frame has invalid command bytes")
+var ERR_FRAME_BAD_TERMINATOR = errors.New("Frame has invalid terminator")
+
+// FrameError describes a frame the Decoder had to throw away
+type FrameError struct {
+	// Err is one of the ERR_FRAME_* errors
+	Err error
+	// Offset is the position in the stream of the byte that made the
+	// frame invalid
+	Offset int64
+	// Command and Size are filled in as far as they were decoded
+	Command byte
+	Size    uint32
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("%s at offset %d (command %#04x, size %d)", e.Err, e.Offset, e.Command, e.Size)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// Frame is a single decoded message
+type Frame struct {
+	Command byte
+	Payload []byte
+}
+
+type decodeState int
+
+const (
+	stateMagic decodeState = iota
+	stateMagic2
+	stateCommand
+	stateCommand2
+	stateSize
+	statePayload
+	stateTerminator
+)
+
+// Decoder is an incremental parser for the framing used on the wire:
+// magic bytes, two command bytes (the first always 0x00), a big endian
+// payload length and the payload. Data is fed in with Write in chunks
+// of any size; complete frames are collected with Next. Bytes outside
+// of a frame, such as report padding, are skipped.
+type Decoder struct {
+	// MaxPayload bounds the announced payload length
+	MaxPayload uint32
+	// Terminated expects BITLOX_TERMINATOR after each payload, which is
+	// how the host ends the frames it sends
+	Terminated bool
+
+	state   decodeState
+	offset  int64
+	cmd     byte
+	size    uint32
+	need    int
+	payload []byte
+	frames  []*Frame
+}
+
+// NewDecoder makes a decoder for frames sent by the device
+func NewDecoder() *Decoder {
+	return &Decoder{MaxPayload: DEFAULT_MAX_FRAME_PAYLOAD}
+}
+
+// Write feeds data into the decoder. Every byte is consumed; if a frame
+// had to be dropped along the way the first such *FrameError is
+// returned, but frames decoded before and after it are still queued.
+func (d *Decoder) Write(data []byte) (int, error) {
+	var firstErr error
+	for i := 0; i < len(data); i++ {
+		if err := d.step(data[i]); err != nil {
+			logger.Debug("dropping frame:", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		d.offset++
+	}
+	return len(data), firstErr
+}
+
+// Next pops the oldest complete frame, or returns nil if there is none
+func (d *Decoder) Next() *Frame {
+	if len(d.frames) == 0 {
+		return nil
+	}
+	f := d.frames[0]
+	d.frames = d.frames[1:]
+	return f
+}
+
+// Pending reports whether the decoder is part way through a frame
+func (d *Decoder) Pending() bool {
+	return d.state != stateMagic
+}
+
+// Close ends the stream, returning a truncated error if it stopped in
+// the middle of a frame
+func (d *Decoder) Close() error {
+	if !d.Pending() {
+		return nil
+	}
+	return d.fail(ERR_FRAME_TRUNCATED)
+}
+
+// Reset drops any partial and queued frames
+func (d *Decoder) Reset() {
+	d.reset()
+	d.frames = nil
+}
+
+func (d *Decoder) reset() {
+	d.state = stateMagic
+	d.cmd = 0
+	d.size = 0
+	d.need = 0
+	d.payload = nil
+}
+
+func (d *Decoder) fail(err error) error {
+	fe := &FrameError{Err: err, Offset: d.offset, Command: d.cmd, Size: d.size}
+	d.reset()
+	return fe
+}
+
+func (d *Decoder) step(b byte) error {
+	switch d.state {
+	case stateMagic:
+		if b == BITLOX_MAGIC[0] {
+			d.state = stateMagic2
+		}
+	case stateMagic2:
+		if b == BITLOX_MAGIC[1] {
+			d.state = stateCommand
+		} else {
+			d.state = stateMagic
+		}
+	case stateCommand:
+		if b == BITLOX_MAGIC[1] {
+			// more than two magic bytes in a row, keep sliding
+			return nil
+		}
+		if b != 0x00 {
+			return d.fail(ERR_FRAME_BAD_COMMAND)
+		}
+		d.state = stateCommand2
+	case stateCommand2:
+		d.cmd = b
+		d.state = stateSize
+		d.need = 4
+	case stateSize:
+		d.size = d.size<<8 | uint32(b)
+		d.need--
+		if d.need > 0 {
+			return nil
+		}
+		if d.size > d.max() {
+			return d.fail(ERR_FRAME_TOO_LARGE)
+		}
+		d.payload = make([]byte, 0, d.size)
+		d.state = statePayload
+		if d.size == 0 {
+			d.endPayload()
+		}
+	case statePayload:
+		d.payload = append(d.payload, b)
+		if uint32(len(d.payload)) == d.size {
+			d.endPayload()
+		}
+	case stateTerminator:
+		if b != BITLOX_TERMINATOR[len(BITLOX_TERMINATOR)-d.need] {
+			return d.fail(ERR_FRAME_BAD_TERMINATOR)
+		}
+		d.need--
+		if d.need == 0 {
+			d.emit()
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) max() uint32 {
+	if d.MaxPayload == 0 {
+		return DEFAULT_MAX_FRAME_PAYLOAD
+	}
+	return d.MaxPayload
+}
+
+func (d *Decoder) endPayload() {
+	if d.Terminated {
+		d.state = stateTerminator
+		d.need = len(BITLOX_TERMINATOR)
+		return
+	}
+	d.emit()
+}
+
+func (d *Decoder) emit() {
+	f := &Frame{Command: d.cmd, Payload: d.payload}
+	if Sensitive([]byte{0x00, f.Command}) {
+		logger.Debugf("parsed: cmd: %#04x size: %d\n", f.Command, len(f.Payload))
+	} else {
+		logger.Debugf("parsed: cmd: %#04x size: %d payload: %x\n", f.Command, len(f.Payload), f.Payload)
+	}
+	d.frames = append(d.frames, f)
+	d.reset()
+}
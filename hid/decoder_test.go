@@ -0,0 +1,56 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FUZZ_MAX_FRAME_PAYLOAD bounds the payloads FuzzDecoder accepts, so a
+// garbage length field cannot have the decoder allocate megabytes for
+// every input
+const FUZZ_MAX_FRAME_PAYLOAD = 1 << 12
+
+// decodeAll feeds data to a fresh decoder in writes of chunkSize bytes
+// and returns the frames it produced
+func decodeAll(data []byte, chunkSize int) []*Frame {
+	dec := &Decoder{MaxPayload: FUZZ_MAX_FRAME_PAYLOAD}
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		dec.Write(data[:n])
+		data = data[n:]
+	}
+	frames := make([]*Frame, 0)
+	for f := dec.Next(); f != nil; f = dec.Next() {
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func FuzzDecoder(f *testing.F) {
+	f.Add([]byte{0x23, 0x23, 0x00, 0x34, 0x00, 0x00, 0x00, 0x03, 'a', 'b', 'c'})
+	f.Add([]byte{0x00, 0x23, 0x23, 0x00, 0x35, 0x00, 0x00, 0x00, 0x00, 0x23, 0x23, 0x01})
+	f.Add([]byte{0x23, 0x23, 0x00, 0x34, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		whole := decodeAll(data, len(data)+1)
+		for _, f := range whole {
+			if uint32(len(f.Payload)) > FUZZ_MAX_FRAME_PAYLOAD {
+				t.Fatalf("payload of %d bytes exceeds the limit", len(f.Payload))
+			}
+		}
+		// how the stream is split into reports must not matter
+		for chunkSize := 1; chunkSize <= CHUNK_SIZE; chunkSize++ {
+			frames := decodeAll(data, chunkSize)
+			if len(frames) != len(whole) {
+				t.Fatalf("chunk size %d: %d frames, %d in one write", chunkSize, len(frames), len(whole))
+			}
+			for i := range frames {
+				if frames[i].Command != whole[i].Command || !bytes.Equal(frames[i].Payload, whole[i].Payload) {
+					t.Fatalf("chunk size %d: frame %d differs", chunkSize, i)
+				}
+			}
+		}
+	})
+}
@@ -3,8 +3,9 @@ package hid
 import (
 	hid "github.com/GeertJohan/go.hid"
 
-	"bytes"
+	"context"
 	"encoding/binary"
+	"io"
 
 	"bitlox/logger"
 	"errors"
@@ -14,169 +15,224 @@ import (
 
 const CHUNK_SIZE = 32
 
+// WRITE_DELAY is the pause the HID device needs between chunks of one
+// write
+const WRITE_DELAY = 50 * time.Millisecond
+
+// READ_POLL is how long a single report read may block before the
+// context is checked again
+const READ_POLL = 100 * time.Millisecond
+
 const MAX_PAYLOAD = ^uint32(0)
 
 var ERR_PAYLOAD_TOO_LARGE = errors.New(fmt.Sprintf("Payload exceeds %d bytes", MAX_PAYLOAD))
+var ERR_NO_DEVICE = errors.New("No device attached")
 
-func GetDevice(vendorId, productId uint16) (*hid.Device, error) {
-	infoList, err := hid.Enumerate(vendorId, productId)
-	if err != nil {
-		return nil, err
-	}
-	device, err := infoList[0].Device()
-	if err != nil {
-		return nil, err
-	}
-	return device, nil
+// hidTransport is the Transport backed by a real HID device
+type hidTransport struct {
+	dev *hid.Device
 }
 
-func Write(dev *hid.Device, data []byte) error {
+func (t *hidTransport) WriteFrame(report []byte) error {
+	_, err := t.dev.Write(report)
+	return err
+}
 
-	var thisWrite, remainder []byte
+func (t *hidTransport) ReadFrame(report []byte, timeout time.Duration) (int, error) {
+	return t.dev.ReadTimeout(report, int(timeout/time.Millisecond))
+}
 
-	// if the data has no terminator, then this is the first run add
-	// the magic bytes and the terminatopr to the data
-	if bytes.Index(data, BITLOX_TERMINATOR) < 0 {
-		data = append(BITLOX_MAGIC, data...)
-		data = append(data, BITLOX_TERMINATOR...)
-	}
-	// prepend the report byte every time
-	data = append(BITLOX_REPORT, data...)
-
-	// test to see if the data is below the chunk size
-	if len(data) < CHUNK_SIZE {
-		// if so, make thisWrite be the full data slice and set the
-		// remainder to an empty slice
-		thisWrite = data
-		remainder = []byte{}
-	} else {
-		logger.Debug("Breaking into chunks")
-		// otherwise, take the front CHUNK_SIZE bytes off the front of
-		// the data and set the rest to the remainder slice
-		thisWrite = data[0:CHUNK_SIZE]
-		remainder = data[CHUNK_SIZE:len(data)]
-	}
-	logger.Debugf("thisWrite %x\n", thisWrite)
-	logger.Debugf("remainder %x\n", remainder)
-	// then write this chunk to the device
-	_, err := dev.Write(thisWrite)
-	if err != nil {
-		logger.Error("write error", err)
-		return err
-	}
-	// if we have a remainder, then recurse
-	if len(remainder) > 0 {
-		time.Sleep(50 * time.Millisecond)
-		return Write(dev, remainder)
-	}
-	// otherwise, return with no errors
-	return nil
+func (t *hidTransport) WriteDelay() time.Duration {
+	return WRITE_DELAY
 }
 
-func WriteVariable(dev *hid.Device, cmd, data []byte) error {
-	dataLen := len(data)
-	if dataLen > int(MAX_PAYLOAD) {
-		return ERR_PAYLOAD_TOO_LARGE
-	}
-	len := make([]byte, 4)
-	binary.BigEndian.PutUint32(len, uint32(dataLen))
-	cmd = append(cmd, len...)
-	cmd = append(cmd, data...)
-	return Write(dev, cmd)
+func (t *hidTransport) Close() error {
+	t.dev.Close()
+	return nil
 }
 
-func Read(dev *hid.Device, buf *bytes.Buffer) error {
-	// make a buffer for this response
-	response := make([]byte, CHUNK_SIZE)
+// DeviceInfo describes an attached device before it is opened
+type DeviceInfo struct {
+	Path    string
+	Serial  string
+	Product string
+}
 
-	// and read into it from the device
-	_, err := dev.Read(response)
+// Enumerate lists every attached device matching the vendor and
+// product ids
+func Enumerate(vendorId, productId uint16) ([]*DeviceInfo, error) {
+	infoList, err := hid.Enumerate(vendorId, productId)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	devices := make([]*DeviceInfo, 0, len(infoList))
+	for _, info := range infoList {
+		devices = append(devices, &DeviceInfo{
+			Path:    info.Path,
+			Serial:  info.SerialNumber,
+			Product: info.Product,
+		})
 	}
+	return devices, nil
+}
 
-	// write the data we got to the buffer passed in
-	_, err = buf.Write(response)
+// Open opens the device at path, as returned by Enumerate
+func Open(path string) (Transport, error) {
+	device, err := hid.OpenPath(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &hidTransport{dev: device}, nil
+}
 
-	bufBytes := buf.Bytes()
-	// test to see if the last byte is 0x23, if so, we may have cut
-	// off magic bytes, so read again
-	if bufBytes[len(bufBytes)-1] == 0x23 {
-		logger.Debug("last byte is 0x23, reading more data")
-		return Read(dev, buf)
-	}
-	//test to see if we have the magic bytes in our buffer
-	magicIndex := bytes.Index(bufBytes, BITLOX_MAGIC)
-	if magicIndex < 0 {
-		logger.Debug("no magic bytes, reading again")
-		return Read(dev, buf)
-	}
-	// now that we know we have magic bytes, strip off the leading
-	// bullshit for the rest of the checks
-	bufBytes = bufBytes[magicIndex:len(bufBytes)]
-	// next, make sure there is enough room to have full command and
-	// payload length bytes
-	if len(bufBytes) < 8 {
-		logger.Debug("Not enough room for command and payload size, reading again")
-		return Read(dev, buf)
+// GetDevice opens the first attached device matching the vendor and
+// product ids
+func GetDevice(vendorId, productId uint16) (Transport, error) {
+	infoList, err := Enumerate(vendorId, productId)
+	if err != nil {
+		return nil, err
 	}
-	// next, get the payload length and make sure we have read the
-	// full payload
-	pSize := getPayloadSize(bufBytes)
-	if len(bufBytes) < (8 + int(pSize)) {
-		logger.Debug("More payload to read, reading again")
-		return Read(dev, buf)
+	if len(infoList) == 0 {
+		return nil, ERR_NO_DEVICE
 	}
-	logger.Debug("finished read")
-	// finally, we have all the data, return nil for no error
-	return nil
+	return Open(infoList[0].Path)
 }
 
-func getCommand(data []byte) []byte {
-	return data[2:4]
+// TimeoutError is returned when a context deadline passes while
+// waiting on the device
+type TimeoutError struct {
+	Op string
 }
 
-func getPayloadSizeBytes(data []byte) []byte {
-	return data[4:8]
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timed out waiting for device %s", e.Op)
 }
 
-func getPayload(data []byte, size int32) []byte {
-	return data[8 : 8+size]
+func (e *TimeoutError) Timeout() bool {
+	return true
 }
 
-func getPayloadSize(data []byte) int32 {
-	sizeB := getPayloadSizeBytes(data)
-	sizeBuf := bytes.NewReader(sizeB)
-	var size int32
-	binary.Read(sizeBuf, binary.BigEndian, &size)
-	return size
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
 }
 
-func ParseResponse(buf *bytes.Buffer) (cmd byte, size int32, payload []byte) {
-	// make a byte slice and read the byte buffer into it (this kills
-	// the buffer)
-	data := make([]byte, buf.Len())
-	buf.Read(data)
+// contextError converts a finished context into the error to hand back
+// to the caller
+func contextError(ctx context.Context, op string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Op: op}
+	}
+	return ctx.Err()
+}
 
-	magicIndex := bytes.Index(data, BITLOX_MAGIC)
-	// strip any leading bullshit
-	data = data[magicIndex : len(data)-1]
+// sleep waits for d or until the context is done
+func sleep(ctx context.Context, d time.Duration, op string) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return contextError(ctx, op)
+	case <-timer.C:
+		return nil
+	}
+}
 
-	// extract the command
-	command := getCommand(data)
-	// all commands have 0x00, get second byte
-	cmd = command[1]
+func Write(ctx context.Context, dev Transport, data []byte) error {
+	// add the magic bytes and the terminator to the data
+	frame := append([]byte{}, BITLOX_MAGIC...)
+	frame = append(frame, data...)
+	frame = append(frame, BITLOX_TERMINATOR...)
 
-	// get the payload size
-	size = getPayloadSize(data)
+	if len(frame) >= CHUNK_SIZE {
+		logger.Debug("Breaking into chunks")
+	}
+	var delay time.Duration
+	if p, ok := dev.(Paced); ok {
+		delay = p.WriteDelay()
+	}
+	logged := &redactor{}
+	for len(frame) > 0 {
+		if err := ctx.Err(); err != nil {
+			return contextError(ctx, "write")
+		}
+		// take up to CHUNK_SIZE-1 bytes off the front of the frame
+		// and prepend the report byte
+		n := CHUNK_SIZE - len(BITLOX_REPORT)
+		if len(frame) < n {
+			n = len(frame)
+		}
+		thisWrite := append(append([]byte{}, BITLOX_REPORT...), frame[0:n]...)
+		frame = frame[n:]
+		redacted, _ := logged.redactReport(thisWrite)
+		logger.Debugf("thisWrite %x\n", redacted)
+		logger.Debugf("remainder %d bytes\n", len(frame))
+		// then write this chunk to the device
+		err := dev.WriteFrame(thisWrite)
+		if err != nil {
+			logger.Error("write error", err)
+			return err
+		}
+		// give the device time to take the chunk before sending more
+		if len(frame) > 0 && delay > 0 {
+			if err := sleep(ctx, delay, "write"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func WriteVariable(ctx context.Context, dev Transport, cmd, data []byte) error {
+	dataLen := len(data)
+	if uint64(dataLen) > uint64(MAX_PAYLOAD) {
+		return ERR_PAYLOAD_TOO_LARGE
+	}
+	len := make([]byte, 4)
+	binary.BigEndian.PutUint32(len, uint32(dataLen))
+	frame := append([]byte{}, cmd...)
+	frame = append(frame, len...)
+	frame = append(frame, data...)
+	return Write(ctx, dev, frame)
+}
 
-	// extract it
-	payload = getPayload(data, size)
+// ReadFrame reads from the device until dec has a complete frame and
+// returns it. Bytes after that frame stay buffered in dec for the next
+// call.
+func ReadFrame(ctx context.Context, dev Transport, dec *Decoder) (*Frame, error) {
+	if f := dec.Next(); f != nil {
+		return f, nil
+	}
 
-	logger.Debugf("parsed: cmd: %#04x size: %d (%#08x) payload: %x\n", cmd, size, size, payload)
-	// return predeclared returns
-	return
+	// make a buffer for the responses
+	response := make([]byte, CHUNK_SIZE)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(ctx, "read")
+		}
+
+		// read into it from the device, giving up after a short while
+		// so the context gets checked again
+		n, err := dev.ReadFrame(response, READ_POLL)
+		if err == io.EOF {
+			if err := dec.Close(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+
+		_, err = dec.Write(response[0:n])
+		if f := dec.Next(); f != nil {
+			return f, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 }
@@ -0,0 +1,47 @@
+package hid_test
+
+import (
+	"bitlox/hid"
+	"bitlox/hid/hidtest"
+
+	"bytes"
+	"context"
+	"testing"
+)
+
+// FUZZ_MAX_PAYLOAD bounds the payloads the fuzz tests decode, so a
+// mangled length field cannot have the decoder allocate gigabytes
+const FUZZ_MAX_PAYLOAD = 1 << 12
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(byte(0x04), []byte{})
+	f.Add(byte(0x54), []byte("password"))
+	f.Add(byte(0x65), bytes.Repeat([]byte{0x7e, 0x23}, 40))
+	f.Fuzz(func(t *testing.T, cmd byte, payload []byte) {
+		if len(payload) > FUZZ_MAX_PAYLOAD {
+			t.Skip("payload larger than FUZZ_MAX_PAYLOAD")
+		}
+		ctx := context.Background()
+		w := hidtest.NewLoopback(nil, hid.CHUNK_SIZE)
+		if err := hid.WriteVariable(ctx, w, []byte{0x00, cmd}, payload); err != nil {
+			t.Fatal(err)
+		}
+		sent := w.Sent()
+		// read back what was sent, as the device would, in reports of
+		// every size up to a full one
+		for chunkSize := 1; chunkSize <= hid.CHUNK_SIZE; chunkSize++ {
+			r := hidtest.NewLoopback(sent, chunkSize)
+			dec := &hid.Decoder{MaxPayload: FUZZ_MAX_PAYLOAD, Terminated: true}
+			frame, err := hid.ReadFrame(ctx, r, dec)
+			if err != nil {
+				t.Fatalf("chunk size %d: %s", chunkSize, err)
+			}
+			if frame.Command != cmd || !bytes.Equal(frame.Payload, payload) {
+				t.Fatalf("chunk size %d: sent %#04x %x, got %#04x %x", chunkSize, cmd, payload, frame.Command, frame.Payload)
+			}
+			if dec.Pending() || dec.Next() != nil {
+				t.Fatalf("chunk size %d: data left after the frame", chunkSize)
+			}
+		}
+	})
+}
@@ -0,0 +1,130 @@
+// Package hidtest has fakes for exercising the hid framing layer
+// without a device: a transport that replays a byte stream in reports
+// and checks that frames survive the writer and the reader intact.
+package hidtest
+
+import (
+	"bitlox/hid"
+
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ERR_REPORT_TOO_LARGE = errors.New(fmt.Sprintf("Report exceeds %d bytes", hid.CHUNK_SIZE))
+
+// Loopback is a Transport that records every report written to it and
+// hands out a fixed stream in reports of ChunkSize bytes, followed by
+// io.EOF
+type Loopback struct {
+	ChunkSize int
+	Written   [][]byte
+	stream    []byte
+}
+
+func NewLoopback(stream []byte, chunkSize int) *Loopback {
+	if chunkSize <= 0 || chunkSize > hid.CHUNK_SIZE {
+		chunkSize = hid.CHUNK_SIZE
+	}
+	return &Loopback{ChunkSize: chunkSize, stream: stream}
+}
+
+func (l *Loopback) WriteFrame(report []byte) error {
+	if len(report) > hid.CHUNK_SIZE {
+		return ERR_REPORT_TOO_LARGE
+	}
+	l.Written = append(l.Written, append([]byte{}, report...))
+	return nil
+}
+
+func (l *Loopback) ReadFrame(report []byte, timeout time.Duration) (int, error) {
+	if len(l.stream) == 0 {
+		return 0, io.EOF
+	}
+	n := l.ChunkSize
+	if n > len(report) {
+		n = len(report)
+	}
+	n = copy(report[0:n], l.stream)
+	l.stream = l.stream[n:]
+	return n, nil
+}
+
+func (l *Loopback) Close() error {
+	return nil
+}
+
+// Sent is everything written so far with the report bytes stripped,
+// i.e. the stream the device would see
+func (l *Loopback) Sent() []byte {
+	var sent []byte
+	for _, report := range l.Written {
+		sent = append(sent, bytes.TrimPrefix(report, hid.BITLOX_REPORT)...)
+	}
+	return sent
+}
+
+// RoundTrip writes payload under command cmd with hid.WriteVariable,
+// decodes what was sent the way the device would and checks that the
+// same frame comes back
+func RoundTrip(cmd byte, payload []byte) error {
+	ctx := context.Background()
+	l := NewLoopback(nil, hid.CHUNK_SIZE)
+	if err := hid.WriteVariable(ctx, l, []byte{0x00, cmd}, payload); err != nil {
+		return err
+	}
+	// bound by what was sent (the default for an empty payload), so a
+	// broken length field fails instead of allocating up to MAX_PAYLOAD
+	dec := &hid.Decoder{MaxPayload: uint32(len(payload)), Terminated: true}
+	if _, err := dec.Write(l.Sent()); err != nil {
+		return err
+	}
+	if dec.Pending() {
+		return hid.ERR_FRAME_TRUNCATED
+	}
+	f := dec.Next()
+	if f == nil {
+		return errors.New("No frame decoded")
+	}
+	if f.Command != cmd || !bytes.Equal(f.Payload, payload) {
+		return fmt.Errorf("Frame mismatch: sent %#04x %x got %#04x %x", cmd, payload, f.Command, f.Payload)
+	}
+	if extra := dec.Next(); extra != nil {
+		return fmt.Errorf("Unexpected extra frame %#04x %x", extra.Command, extra.Payload)
+	}
+	return nil
+}
+
+// Decode reads data as if it came from the device in reports of
+// chunkSize bytes and returns every frame hid.ReadFrame produced along
+// with the error that ended the stream. Frames the decoder rejected
+// are skipped, not fatal.
+func Decode(data []byte, chunkSize int) ([]*hid.Frame, error) {
+	ctx := context.Background()
+	l := NewLoopback(data, chunkSize)
+	dec := hid.NewDecoder()
+	frames := make([]*hid.Frame, 0)
+	for {
+		f, err := hid.ReadFrame(ctx, l, dec)
+		if err != nil {
+			var fe *hid.FrameError
+			if errors.As(err, &fe) && fe.Err != hid.ERR_FRAME_TRUNCATED {
+				continue
+			}
+			return frames, err
+		}
+		frames = append(frames, f)
+	}
+}
+
+// EncodeResponse builds the byte stream for a frame as the device
+// sends it, without a terminator
+func EncodeResponse(cmd byte, payload []byte) []byte {
+	size := uint32(len(payload))
+	frame := append([]byte{}, hid.BITLOX_MAGIC...)
+	frame = append(frame, 0x00, cmd, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+	return append(frame, payload...)
+}
@@ -0,0 +1,107 @@
+package hid
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// SENSITIVE_COMMANDS carry passwords, seeds, one time passwords, key
+// material or the contents of the device in their payload, which is
+// never logged or traced. Requests and responses are both listed, their
+// command bytes do not overlap.
+var SENSITIVE_COMMANDS = [][]byte{
+	PREFIX_PIN_ACK,
+	PREFIX_OTP_ACK,
+	PREFIX_NEW_WALLET,
+	PREFIX_RESTORE_WALLET,
+	PREFIX_CHANGE_ENCRYPTION_KEY,
+	PREFIX_SET_BULK,
+	{0x00, RESPONSE_ENTROPY},
+	{0x00, RESPONSE_BULK},
+}
+
+// Sensitive reports whether a frame starting with cmd carries a payload
+// that must not be logged
+func Sensitive(cmd []byte) bool {
+	for _, s := range SENSITIVE_COMMANDS {
+		if bytes.HasPrefix(cmd, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// the magic, 2 byte command and 4 byte payload length
+const frameHeaderSize = 8
+
+// redactor blanks the payload of sensitive frames in a stream of
+// reports going one way, following each frame across reports
+type redactor struct {
+	// the frame header seen so far
+	header []byte
+	// payload bytes of a sensitive frame still to come. Other frames
+	// are not skipped: a length that cannot be trusted must not hide
+	// the sensitive frame after it.
+	remaining int
+}
+
+// redactReport is redact for a report the host writes, which starts
+// with the report byte
+func (r *redactor) redactReport(report []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(report, BITLOX_REPORT) {
+		return report, false
+	}
+	data, redacted := r.redact(report[len(BITLOX_REPORT):])
+	if !redacted {
+		return report, false
+	}
+	return append(append([]byte{}, BITLOX_REPORT...), data...), true
+}
+
+// redact returns data with any sensitive payload bytes zeroed, and
+// whether it changed anything. data itself is left alone.
+func (r *redactor) redact(data []byte) ([]byte, bool) {
+	var redacted []byte
+	for i, b := range data {
+		if r.remaining > 0 {
+			r.remaining--
+			if redacted == nil {
+				redacted = append([]byte{}, data...)
+			}
+			redacted[i] = 0
+			continue
+		}
+		r.scanHeader(b)
+	}
+	if redacted == nil {
+		return data, false
+	}
+	return redacted, true
+}
+
+// scanHeader looks for a frame header one byte at a time, the way the
+// Decoder does
+func (r *redactor) scanHeader(b byte) {
+	switch {
+	case len(r.header) < len(BITLOX_MAGIC):
+		if b == BITLOX_MAGIC[len(r.header)] {
+			r.header = append(r.header, b)
+		} else if b == BITLOX_MAGIC[0] {
+			r.header = append(r.header[:0], b)
+		} else {
+			r.header = r.header[:0]
+		}
+		return
+	case len(r.header) == len(BITLOX_MAGIC) && b == BITLOX_MAGIC[1]:
+		// more than two magic bytes in a row, keep sliding
+		return
+	}
+	r.header = append(r.header, b)
+	if len(r.header) < frameHeaderSize {
+		return
+	}
+	if Sensitive(r.header[len(BITLOX_MAGIC):]) {
+		r.remaining = int(binary.BigEndian.Uint32(r.header[frameHeaderSize-4:]))
+	}
+	r.header = r.header[:0]
+}
@@ -0,0 +1,62 @@
+package hid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// frame builds a frame as it appears on the wire, without terminator
+func frame(cmd byte, payload []byte) []byte {
+	f := append([]byte{}, BITLOX_MAGIC...)
+	f = append(f, 0x00, cmd, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(f[4:8], uint32(len(payload)))
+	return append(f, payload...)
+}
+
+// TestRedactAcrossReports checks that sensitive payloads are blanked
+// however the stream is split into reports, with the header split too
+func TestRedactAcrossReports(t *testing.T) {
+	secret := bytes.Repeat([]byte{0xaa}, 45)
+	public := bytes.Repeat([]byte{0x55}, 20)
+	stream := frame(RESPONSE_SUCCESS, public)
+	stream = append(stream, frame(RESPONSE_ENTROPY, secret)...)
+	stream = append(stream, frame(RESPONSE_PING, public)...)
+	expected := frame(RESPONSE_SUCCESS, public)
+	expected = append(expected, frame(RESPONSE_ENTROPY, make([]byte, len(secret)))...)
+	expected = append(expected, frame(RESPONSE_PING, public)...)
+
+	for chunkSize := 1; chunkSize <= CHUNK_SIZE; chunkSize++ {
+		r := &redactor{}
+		var got []byte
+		for data := stream; len(data) > 0; {
+			n := chunkSize
+			if n > len(data) {
+				n = len(data)
+			}
+			redacted, _ := r.redact(data[:n])
+			got = append(got, redacted...)
+			data = data[n:]
+		}
+		if !bytes.Equal(got, expected) {
+			t.Fatalf("chunk size %d: got %x", chunkSize, got)
+		}
+	}
+	if !bytes.Contains(stream, secret) {
+		t.Fatalf("redact changed its input")
+	}
+}
+
+func TestRedactReport(t *testing.T) {
+	password := []byte("correct horse")
+	r := &redactor{}
+	report := append(append([]byte{}, BITLOX_REPORT...), frame(PREFIX_PIN_ACK[1], password)...)
+	redacted, ok := r.redactReport(report)
+	if !ok || bytes.Contains(redacted, password) || len(redacted) != len(report) {
+		t.Fatalf("password not redacted: %x", redacted)
+	}
+	ping := append(append([]byte{}, BITLOX_REPORT...), frame(COMMAND_PING[1], []byte("Hello"))...)
+	if same, ok := r.redactReport(ping); ok || !bytes.Equal(same, ping) {
+		t.Fatalf("ping redacted: %x", same)
+	}
+}
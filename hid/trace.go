@@ -0,0 +1,245 @@
+package hid
+
+import (
+	"bitlox/logger"
+
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Traces are plain text, one report per line:
+//
+//	2015-11-02T15:04:05.123456Z > 0023230010000000007e7e
+//	2015-11-02T15:04:05.131002Z < 2323003200000012...
+//
+// where > is host to device and < is device to host. Blank lines and
+// lines starting with # are ignored. The payloads of Sensitive
+// commands and responses are recorded as zeros, with TRACE_REDACTED
+// after the report. Replaying such a response gives back the zeros.
+const (
+	TRACE_WRITE    = ">"
+	TRACE_READ     = "<"
+	TRACE_REDACTED = "redacted"
+)
+
+var ERR_REPLAY_DONE = errors.New("Replay has no more reports")
+
+// ReplayMismatchError is returned by a strict Replay when the host
+// writes something other than what the trace recorded
+type ReplayMismatchError struct {
+	Line     int
+	Expected []byte
+	Got      []byte
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("Replay line %d: expected write %x, got %x", e.Line, e.Expected, e.Got)
+}
+
+// Recorder is a Transport that passes everything through to another
+// Transport and writes each report to a trace, leaving out passwords,
+// seeds and one time passwords
+type Recorder struct {
+	t       Transport
+	w       io.Writer
+	written redactor
+	read    redactor
+	mu      sync.Mutex
+}
+
+// NewRecorder records the traffic on t to w. If w is an io.Closer it
+// is closed along with the recorder.
+func NewRecorder(t Transport, w io.Writer) *Recorder {
+	return &Recorder{t: t, w: w}
+}
+
+func (r *Recorder) record(dir string, report []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var redacted bool
+	if dir == TRACE_WRITE {
+		report, redacted = r.written.redactReport(report)
+	} else {
+		report, redacted = r.read.redact(report)
+	}
+	suffix := ""
+	if redacted {
+		suffix = " " + TRACE_REDACTED
+	}
+	logger.Debugf("%s %x%s\n", dir, report, suffix)
+	_, err := fmt.Fprintf(r.w, "%s %s %x%s\n", time.Now().UTC().Format(time.RFC3339Nano), dir, report, suffix)
+	if err != nil {
+		logger.Warn("trace write error", err)
+	}
+}
+
+func (r *Recorder) WriteFrame(report []byte) error {
+	r.record(TRACE_WRITE, report)
+	return r.t.WriteFrame(report)
+}
+
+func (r *Recorder) ReadFrame(report []byte, timeout time.Duration) (int, error) {
+	n, err := r.t.ReadFrame(report, timeout)
+	if n > 0 {
+		r.record(TRACE_READ, report[0:n])
+	}
+	return n, err
+}
+
+func (r *Recorder) WriteDelay() time.Duration {
+	if p, ok := r.t.(Paced); ok {
+		return p.WriteDelay()
+	}
+	return 0
+}
+
+func (r *Recorder) Close() error {
+	err := r.t.Close()
+	if c, ok := r.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type traceEntry struct {
+	line     int
+	dir      string
+	report   []byte
+	redacted bool
+}
+
+// Replay is a Transport that plays back a recorded trace: reads return
+// the recorded device reports in order and writes are checked against
+// the recorded host reports
+type Replay struct {
+	// Strict makes a write that differs from the trace an error rather
+	// than a warning
+	Strict bool
+
+	entries []traceEntry
+	next    int
+	mu      sync.Mutex
+}
+
+// NewReplay parses a trace written by a Recorder
+func NewReplay(r io.Reader) (*Replay, error) {
+	replay := &Replay{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 || len(fields) > 4 || (fields[1] != TRACE_WRITE && fields[1] != TRACE_READ) {
+			return nil, fmt.Errorf("Invalid trace line %d: %q", line, text)
+		}
+		redacted := len(fields) == 4
+		if redacted && fields[3] != TRACE_REDACTED {
+			return nil, fmt.Errorf("Invalid trace line %d: %q", line, text)
+		}
+		report, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trace line %d: %s", line, err)
+		}
+		replay.entries = append(replay.entries, traceEntry{line: line, dir: fields[1], report: report, redacted: redacted})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
+
+func (r *Replay) WriteFrame(report []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next >= len(r.entries) {
+		return ERR_REPLAY_DONE
+	}
+	entry := r.entries[r.next]
+	if entry.dir != TRACE_WRITE || !entry.matches(report) {
+		err := &ReplayMismatchError{Line: entry.line, Got: report}
+		if entry.dir == TRACE_WRITE {
+			err.Expected = entry.report
+		}
+		if r.Strict {
+			return err
+		}
+		logger.Warn(err)
+		if entry.dir != TRACE_WRITE {
+			// the trace expected a read here, leave it in place
+			return nil
+		}
+	}
+	r.next++
+	return nil
+}
+
+// matches reports whether the host writing report agrees with the
+// trace. A redacted report only has to agree in length, the payload
+// that was blanked out can be anything.
+func (e *traceEntry) matches(report []byte) bool {
+	if e.redacted {
+		return len(e.report) == len(report)
+	}
+	return bytes.Equal(e.report, report)
+}
+
+func (r *Replay) ReadFrame(report []byte, timeout time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// skip over any writes the host never made
+	for r.next < len(r.entries) && r.entries[r.next].dir == TRACE_WRITE {
+		entry := r.entries[r.next]
+		err := &ReplayMismatchError{Line: entry.line, Expected: entry.report}
+		if r.Strict {
+			return 0, err
+		}
+		logger.Warn(err)
+		r.next++
+	}
+	if r.next >= len(r.entries) {
+		return 0, io.EOF
+	}
+	n := copy(report, r.entries[r.next].report)
+	r.next++
+	return n, nil
+}
+
+// RecordedWrite is the payload of the first frame with command cmd the
+// host wrote in the trace, or nil if it wrote none. Replaying needs
+// some of those values back, such as the session id the recorded
+// device echoes.
+func (r *Replay) RecordedWrite(cmd byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dec := &Decoder{Terminated: true}
+	for _, entry := range r.entries {
+		if entry.dir != TRACE_WRITE {
+			continue
+		}
+		dec.Write(bytes.TrimPrefix(entry.report, BITLOX_REPORT))
+		for f := dec.Next(); f != nil; f = dec.Next() {
+			if f.Command == cmd {
+				return f.Payload
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Replay) Close() error {
+	return nil
+}
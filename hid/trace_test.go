@@ -0,0 +1,105 @@
+package hid_test
+
+import (
+	"bitlox"
+	"bitlox/hid"
+	"bitlox/simulator"
+
+	"bytes"
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// session is what the round trip test does with the device, once for
+// real and once replayed
+func session(t *testing.T, transport hid.Transport) {
+	ctx := context.Background()
+	d, err := bitlox.Open(ctx, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := bitlox.Ping(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := bitlox.LoadWallet(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bitlox.ScanWallet(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRecordReplay records a session with the simulator and replays it
+// strictly, so every write must match the trace, the random session id
+// included
+func TestRecordReplay(t *testing.T) {
+	sim, err := simulator.New(simulator.TestSeed, "Test wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := &bytes.Buffer{}
+	session(t, hid.NewRecorder(sim, trace))
+
+	replay, err := hid.NewReplay(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Strict = true
+	session(t, replay)
+}
+
+// streams joins the reports of a trace into what the host wrote and
+// what the device sent
+func streams(t *testing.T, trace []byte) (written, read []byte) {
+	for _, line := range strings.Split(strings.TrimSpace(string(trace)), "\n") {
+		fields := strings.Fields(line)
+		report, err := hex.DecodeString(fields[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fields[1] == hid.TRACE_WRITE {
+			written = append(written, bytes.TrimPrefix(report, hid.BITLOX_REPORT)...)
+		} else {
+			read = append(read, report...)
+		}
+	}
+	return written, read
+}
+
+// TestTraceRedacts checks that a password the host sends and entropy
+// the device sends back are kept out of the trace
+func TestTraceRedacts(t *testing.T) {
+	ctx := context.Background()
+	sim, err := simulator.New(simulator.TestSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := &bytes.Buffer{}
+	d, err := bitlox.Open(ctx, hid.NewRecorder(sim, trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	password := []byte("correct horse battery staple")
+	if err := bitlox.NewWallet(ctx, d, 0, "locked", password, false); err != nil {
+		t.Fatal(err)
+	}
+	entropy, err := bitlox.GetEntropy(ctx, d, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, read := streams(t, trace.Bytes())
+	if bytes.Contains(written, password) {
+		t.Errorf("password in the trace")
+	}
+	if bytes.Contains(read, entropy[:16]) {
+		t.Errorf("entropy in the trace")
+	}
+	if !strings.Contains(trace.String(), hid.TRACE_REDACTED) {
+		t.Errorf("nothing marked %s", hid.TRACE_REDACTED)
+	}
+}
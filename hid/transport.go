@@ -0,0 +1,27 @@
+package hid
+
+import (
+	"time"
+)
+
+// Transport is a link to a BitLox that moves single HID reports of at
+// most CHUNK_SIZE bytes. The framing in this package is built on top
+// of it, so the physical HID device is just one backend and
+// simulators or recorders can be plugged in instead.
+type Transport interface {
+	// WriteFrame sends one report to the device
+	WriteFrame(report []byte) error
+	// ReadFrame reads one report from the device into report and
+	// returns the number of bytes read. It returns 0 and no error if
+	// nothing arrived within timeout.
+	ReadFrame(report []byte, timeout time.Duration) (int, error)
+	// Close releases the link
+	Close() error
+}
+
+// Paced is implemented by transports that need a pause between the
+// reports of one write. Transports without it are written to as fast
+// as Write can go.
+type Paced interface {
+	WriteDelay() time.Duration
+}
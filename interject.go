@@ -0,0 +1,207 @@
+package bitlox
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"bitlox/hid"
+	"bitlox/logger"
+	models "bitlox/proto"
+
+	"context"
+	"errors"
+	"time"
+)
+
+// how long sending a cancel may take. It is not bound by the command's
+// context, which may be what caused the cancel.
+const CANCEL_TIMEOUT = 5 * time.Second
+
+var ERR_CANCELLED = errors.New("Cancelled")
+var ERR_PIN_REQUIRED = errors.New("Device asked for a password and none was given")
+var ERR_OTP_REQUIRED = errors.New("Device asked for a one time password and none was given")
+
+// Interjector answers the requests a device can make before it responds
+// to a command: pressing a button, entering the wallet password or
+// entering a one time password shown on its screen. Returning an error
+// from any of them cancels the command; the matching cancel is sent to
+// the device and the command returns that error.
+type Interjector interface {
+	ButtonRequest(ctx context.Context) error
+	PinRequest(ctx context.Context) ([]byte, error)
+	OtpRequest(ctx context.Context) (string, error)
+}
+
+// Interjections is an Interjector made of functions, such as a prompt
+// or a test stub. A nil function gets the default behaviour: button
+// requests are acknowledged, password and OTP requests are cancelled
+// with ERR_PIN_REQUIRED and ERR_OTP_REQUIRED.
+type Interjections struct {
+	Button func(ctx context.Context) error
+	Pin    func(ctx context.Context) ([]byte, error)
+	Otp    func(ctx context.Context) (string, error)
+}
+
+func (i *Interjections) ButtonRequest(ctx context.Context) error {
+	if i.Button == nil {
+		return nil
+	}
+	return i.Button(ctx)
+}
+
+func (i *Interjections) PinRequest(ctx context.Context) ([]byte, error) {
+	if i.Pin == nil {
+		return nil, ERR_PIN_REQUIRED
+	}
+	return i.Pin(ctx)
+}
+
+func (i *Interjections) OtpRequest(ctx context.Context) (string, error) {
+	if i.Otp == nil {
+		return "", ERR_OTP_REQUIRED
+	}
+	return i.Otp(ctx)
+}
+
+// SetInterjector sets what answers the device's interjections, nil
+// restores the default of acknowledging button requests only
+func (d *Device) SetInterjector(i Interjector) {
+	d.interjector = i
+}
+
+func (d *Device) getInterjector() Interjector {
+	if d.interjector == nil {
+		return &Interjections{}
+	}
+	return d.interjector
+}
+
+// SetTimeout bounds each exchange with the device: sending a command
+// or an answer and waiting for what comes back. Time spent answering
+// an interjection, typing a password say, does not count against it.
+// Zero leaves calls bound only by their context.
+func (d *Device) SetTimeout(timeout time.Duration) {
+	d.timeout = timeout
+}
+
+// call sends a command and returns the device's response, answering
+// any interjections on the way. With a nil m, cmd is sent as it is;
+// otherwise m is marshalled and sent with cmd as the prefix.
+func call(ctx context.Context, d *Device, cmd []byte, m proto.Message) (*hid.Frame, error) {
+	d.pinAnswered = false
+	res, err := exchange(ctx, d, cmd, m)
+	// set while the device waits for its button to be pressed
+	buttonWait := false
+	for {
+		if err != nil {
+			if buttonWait && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+				// Ctrl-C or the timeout: the device would otherwise
+				// keep waiting and answer the next command with this
+				// one's response
+				cancelInterjection(d, hid.COMMAND_BUTTON_CANCEL)
+			}
+			return nil, err
+		}
+
+		var reply []byte
+		var replyMsg proto.Message
+		var cancel []byte
+		i := d.getInterjector()
+		buttonWait = false
+
+		switch res.Command {
+		case hid.RESPONSE_PLEASE_ACK:
+			logger.Debug("device requests button press")
+			err = i.ButtonRequest(ctx)
+			reply, cancel = hid.COMMAND_ACK, hid.COMMAND_BUTTON_CANCEL
+			buttonWait = true
+			d.pinAnswered = false
+		case hid.RESPONSE_PIN_REQUEST:
+			logger.Debug("device requests password")
+			var password []byte
+			password, err = i.PinRequest(ctx)
+			reply, replyMsg, cancel = hid.PREFIX_PIN_ACK, &models.PinAck{Password: password}, hid.COMMAND_PIN_CANCEL
+			d.pinAnswered = err == nil
+		case hid.RESPONSE_OTP_REQUEST:
+			logger.Debug("device requests OTP")
+			var otp string
+			otp, err = i.OtpRequest(ctx)
+			reply, replyMsg, cancel = hid.PREFIX_OTP_ACK, &models.OtpAck{Otp: otp}, hid.COMMAND_OTP_CANCEL
+			d.pinAnswered = false
+		default:
+			return res, nil
+		}
+
+		if err != nil {
+			cancelInterjection(d, cancel)
+			return nil, err
+		}
+		res, err = exchange(ctx, d, reply, replyMsg)
+	}
+}
+
+// exchange sends cmd and reads the next frame from the device, within
+// the device's timeout
+func exchange(ctx context.Context, d *Device, cmd []byte, m proto.Message) (*hid.Frame, error) {
+	if d.timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, d.timeout)
+		defer stop()
+	}
+	if err := send(ctx, d, cmd, m); err != nil {
+		return nil, err
+	}
+	return hid.ReadFrame(ctx, d.t, d.dec)
+}
+
+// cancelInterjection sends cancel and reads the response the device
+// gives to it, normally a Failure, so the next command starts clean
+func cancelInterjection(d *Device, cancel []byte) {
+	logger.Debug("cancelling interjection")
+	ctx, stop := context.WithTimeout(context.Background(), CANCEL_TIMEOUT)
+	defer stop()
+	if err := hid.Write(ctx, d.t, cancel); err != nil {
+		logger.Debug("cancel failed", err)
+		return
+	}
+	res, err := hid.ReadFrame(ctx, d.t, d.dec)
+	if err != nil {
+		logger.Debug("cancel response", err)
+		return
+	}
+	logger.Debugf("cancel response: cmd: %#04x\n", res.Command)
+}
+
+func send(ctx context.Context, d *Device, cmd []byte, m proto.Message) error {
+	if m == nil {
+		return hid.Write(ctx, d.t, cmd)
+	}
+	mBytes, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return hid.WriteVariable(ctx, d.t, cmd, mBytes)
+}
+
+// callSuccess is call for commands answered by Success or Failure
+func callSuccess(ctx context.Context, d *Device, cmd []byte, m proto.Message) error {
+	res, err := call(ctx, d, cmd, m)
+	if err != nil {
+		return err
+	}
+	return expectSuccess(res)
+}
+
+// callExpect is call for commands answered by a message, which is
+// unmarshalled into out if the response command is want
+func callExpect(ctx context.Context, d *Device, cmd []byte, m proto.Message, want byte, out proto.Message) error {
+	res, err := call(ctx, d, cmd, m)
+	if err != nil {
+		return err
+	}
+	if res.Command == hid.RESPONSE_ERROR {
+		return parseFailure(res.Payload)
+	} else if res.Command != want {
+		return ERR_UNRECOGNIZED_RETURN
+	}
+	return proto.Unmarshal(res.Payload, out)
+}
@@ -0,0 +1,68 @@
+package bitlox_test
+
+import (
+	"bitlox"
+	"bitlox/simulator"
+
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCancelButtonWait cancels a command while the device waits for its
+// button, then presses the button late. The cancel must have reached
+// the device, or the next command would read the late response.
+func TestCancelButtonWait(t *testing.T) {
+	sim, err := simulator.New(simulator.TestSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.HoldButton = true
+	d, err := bitlox.Open(context.Background(), sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = bitlox.ChangeDeviceName(ctx, d, "renamed")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	sim.PressButton()
+	if sim.DeviceName == "renamed" {
+		t.Fatalf("the cancelled rename went ahead")
+	}
+	if err := bitlox.Ping(context.Background(), d); err != nil {
+		t.Fatalf("ping after the cancel: %s", err)
+	}
+}
+
+// TestButtonWaitTimeout is TestCancelButtonWait with the device timeout
+// running out instead of Ctrl-C
+func TestButtonWaitTimeout(t *testing.T) {
+	sim, err := simulator.New(simulator.TestSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.HoldButton = true
+	d, err := bitlox.Open(context.Background(), sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.SetTimeout(50 * time.Millisecond)
+
+	err = bitlox.ChangeDeviceName(context.Background(), d, "renamed")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	sim.PressButton()
+	if err := bitlox.Ping(context.Background(), d); err != nil {
+		t.Fatalf("ping after the timeout: %s", err)
+	}
+}
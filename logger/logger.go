@@ -3,6 +3,7 @@ package logger
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
@@ -91,12 +92,21 @@ func Fatalf(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+// output of Log and Logf
+var logOutput io.Writer = os.Stdout
+
+// SetOutput sends Log and Logf output to w, e.g. os.Stderr when stdout
+// carries data
+func SetOutput(w io.Writer) {
+	logOutput = w
+}
+
 func Log(args ...interface{}) {
-	fmt.Println(args...)
+	fmt.Fprintln(logOutput, args...)
 }
 
 func Logf(format string, args ...interface{}) {
-	fmt.Printf(format, args...)
+	fmt.Fprintf(logOutput, format, args...)
 }
 
 func EnableDebug() {
@@ -0,0 +1,64 @@
+package bitlox
+
+import (
+	"github.com/tyler-smith/go-bip39"
+
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the firmware takes the 512 bit BIP39 seed, not the mnemonic itself
+const SEED_LENGTH = 64
+
+var ERR_SEED_LENGTH = errors.New(fmt.Sprintf("Seed must be %d bytes", SEED_LENGTH))
+var ERR_MNEMONIC_LENGTH = errors.New("Mnemonic must be 12, 15, 18, 21 or 24 words")
+var ERR_MNEMONIC_CHECKSUM = errors.New("Mnemonic checksum is incorrect, check the words and their order")
+
+// UnknownWordError is returned for a mnemonic word that is not in the
+// BIP39 English wordlist
+type UnknownWordError struct {
+	// Position counts from 1
+	Position int
+	Word     string
+}
+
+func (e *UnknownWordError) Error() string {
+	return fmt.Sprintf("Word %d (%q) is not in the BIP39 wordlist", e.Position, e.Word)
+}
+
+// NormalizeMnemonic lower cases the words of a mnemonic and joins them
+// with single spaces, which is the form the seed is derived from
+func NormalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// ValidateMnemonic checks the word count, that every word is in the
+// wordlist and the checksum
+func ValidateMnemonic(mnemonic string) error {
+	words := strings.Fields(NormalizeMnemonic(mnemonic))
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return ERR_MNEMONIC_LENGTH
+	}
+	for i, word := range words {
+		if _, ok := bip39.GetWordIndex(word); !ok {
+			return &UnknownWordError{Position: i + 1, Word: word}
+		}
+	}
+	if _, err := bip39.EntropyFromMnemonic(strings.Join(words, " ")); err != nil {
+		if err == bip39.ErrChecksumIncorrect {
+			return ERR_MNEMONIC_CHECKSUM
+		}
+		return err
+	}
+	return nil
+}
+
+// SeedFromMnemonic validates a BIP39 mnemonic and derives the seed
+// RestoreWallet expects. The passphrase may be empty.
+func SeedFromMnemonic(mnemonic string, passphrase string) ([]byte, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	return bip39.NewSeed(NormalizeMnemonic(mnemonic), passphrase), nil
+}
@@ -0,0 +1,129 @@
+package bitlox
+
+import (
+	models "bitlox/proto"
+
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var ERR_PASSWORD_ENV_UNSET = errors.New("Password environment variable is not set")
+
+// WrongPasswordError is returned when the device rejects the password
+// given for a wallet
+type WrongPasswordError struct {
+	Wallet int
+}
+
+func (e *WrongPasswordError) Error() string {
+	return fmt.Sprintf("Wrong password for wallet %d", e.Wallet)
+}
+
+// PasswordProvider supplies a wallet password when the device asks for
+// one
+type PasswordProvider interface {
+	Password(ctx context.Context) ([]byte, error)
+}
+
+// PasswordFunc makes a function a PasswordProvider, e.g. a terminal
+// prompt
+type PasswordFunc func(ctx context.Context) ([]byte, error)
+
+func (f PasswordFunc) Password(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// EnvPassword reads the password from an environment variable, for
+// scripts
+type EnvPassword string
+
+func (name EnvPassword) Password(ctx context.Context) ([]byte, error) {
+	password, ok := os.LookupEnv(string(name))
+	if !ok {
+		return nil, ERR_PASSWORD_ENV_UNSET
+	}
+	return []byte(password), nil
+}
+
+// FDPassword reads the password from the first line of an open file
+// descriptor, such as a pipe set up by the caller. The descriptor is
+// read once and the password reused after that.
+type FDPassword struct {
+	FD uintptr
+
+	once     sync.Once
+	password []byte
+	err      error
+}
+
+func (p *FDPassword) Password(ctx context.Context) ([]byte, error) {
+	p.once.Do(func() {
+		f := os.NewFile(p.FD, fmt.Sprintf("fd%d", p.FD))
+		if f == nil {
+			p.err = fmt.Errorf("Invalid file descriptor %d", p.FD)
+			return
+		}
+		defer f.Close()
+		line, err := bufio.NewReader(f).ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			p.err = err
+			return
+		}
+		p.password = bytes.TrimRight(line, "\r\n")
+	})
+	return p.password, p.err
+}
+
+// passwordInterjector answers password requests from a provider and
+// leaves everything else to the device's own interjector
+type passwordInterjector struct {
+	Interjector
+	p PasswordProvider
+}
+
+func (i *passwordInterjector) PinRequest(ctx context.Context) ([]byte, error) {
+	return i.p.Password(ctx)
+}
+
+// UnlockWallet loads a wallet that may be encrypted, taking its
+// password from p. While the device reports the password as wrong, p
+// is asked again, up to attempts times in all; a provider that always
+// gives the same answer should only get one attempt.
+func UnlockWallet(ctx context.Context, d *Device, number byte, p PasswordProvider, attempts int) error {
+	saved := d.interjector
+	d.interjector = &passwordInterjector{Interjector: d.getInterjector(), p: p}
+	defer func() { d.interjector = saved }()
+
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		err = LoadWallet(ctx, d, number)
+		var wrong *WrongPasswordError
+		if !errors.As(err, &wrong) {
+			return err
+		}
+	}
+	return err
+}
+
+// wrongPassword turns the device's Failure for a rejected password into
+// a *WrongPasswordError. There is no documented Failure code for a
+// wrong password, so a Failure counts as one only when it is the
+// device's answer to the password itself. Once the device goes on to
+// ask for a button press or a one time password it has accepted the
+// password, and a later Failure is about something else.
+func wrongPassword(d *Device, err error, number byte) error {
+	var failure *models.Failure
+	if errors.As(err, &failure) && d.pinAnswered {
+		return &WrongPasswordError{Wallet: int(number)}
+	}
+	return err
+}
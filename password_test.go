@@ -0,0 +1,96 @@
+package bitlox_test
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"bitlox"
+	"bitlox/hid"
+	"bitlox/hid/hidtest"
+	models "bitlox/proto"
+	"bitlox/simulator"
+
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestUnlockWalletWrongPassword(t *testing.T) {
+	ctx := context.Background()
+	sim, err := simulator.New(simulator.TestSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := bitlox.Open(ctx, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := bitlox.NewWallet(ctx, d, 0, "locked", []byte("right"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	asked := 0
+	wrong := bitlox.PasswordFunc(func(ctx context.Context) ([]byte, error) {
+		asked++
+		return []byte("wrong"), nil
+	})
+	err = bitlox.UnlockWallet(ctx, d, 0, wrong, 2)
+	var wrongPassword *bitlox.WrongPasswordError
+	if !errors.As(err, &wrongPassword) {
+		t.Fatalf("expected a WrongPasswordError, got %v", err)
+	}
+	if asked != 2 {
+		t.Errorf("password asked for %d times, expected 2", asked)
+	}
+
+	right := bitlox.PasswordFunc(func(ctx context.Context) ([]byte, error) {
+		return []byte("right"), nil
+	})
+	if err := bitlox.UnlockWallet(ctx, d, 0, right, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadWalletMissingIsNotWrongPassword(t *testing.T) {
+	ctx := context.Background()
+	sim, err := simulator.New(simulator.TestSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := bitlox.Open(ctx, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	err = bitlox.LoadWallet(ctx, d, 7)
+	var wrongPassword *bitlox.WrongPasswordError
+	if err == nil || errors.As(err, &wrongPassword) {
+		t.Fatalf("expected the device's Failure, got %v", err)
+	}
+}
+
+// TestOtpFailureIsNotWrongPassword has the device take the password,
+// ask for a one time password and then fail, as it would for a wrong
+// one time password
+func TestOtpFailureIsNotWrongPassword(t *testing.T) {
+	failure, err := proto.Marshal(&models.Failure{Code: 9, Message: []byte("Invalid OTP")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := hidtest.EncodeResponse(hid.RESPONSE_PIN_REQUEST, nil)
+	stream = append(stream, hidtest.EncodeResponse(hid.RESPONSE_OTP_REQUEST, nil)...)
+	stream = append(stream, hidtest.EncodeResponse(hid.RESPONSE_ERROR, failure)...)
+	d := bitlox.NewDevice(hidtest.NewLoopback(stream, hid.CHUNK_SIZE))
+	d.SetInterjector(&bitlox.Interjections{
+		Pin: func(ctx context.Context) ([]byte, error) { return []byte("right"), nil },
+		Otp: func(ctx context.Context) (string, error) { return "0000", nil },
+	})
+
+	err = bitlox.LoadWallet(context.Background(), d, 0)
+	var wrongPassword *bitlox.WrongPasswordError
+	var deviceFailure *models.Failure
+	if errors.As(err, &wrongPassword) || !errors.As(err, &deviceFailure) {
+		t.Fatalf("expected the device's Failure, got %v", err)
+	}
+}
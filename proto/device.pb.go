@@ -0,0 +1,237 @@
+package proto
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DeviceUUID is the response to a get device uuid command
+type DeviceUUID struct {
+	UUID []byte `protobuf:"bytes,1,req,name=device_uuid"`
+}
+
+func (m *DeviceUUID) Reset() {
+	m = &DeviceUUID{}
+}
+
+func (m *DeviceUUID) String() string {
+	return fmt.Sprintf("%x", m.UUID)
+}
+
+func (m *DeviceUUID) ProtoMessage() {}
+
+// Ping checks the device is alive, the greeting is echoed back in
+// PingResponse
+type Ping struct {
+	Greeting string `protobuf:"bytes,1,opt,name=greeting"`
+}
+
+func (m *Ping) Reset() {
+	m = &Ping{}
+}
+
+func (m *Ping) String() string {
+	return fmt.Sprintf("ping %s", m.Greeting)
+}
+
+func (m *Ping) ProtoMessage() {}
+
+// PingResponse echoes the greeting and the session id from the most
+// recent Initialize (all zeros if there was none since reset)
+type PingResponse struct {
+	EchoedGreeting  string `protobuf:"bytes,1,opt,name=echoed_greeting"`
+	EchoedSessionID []byte `protobuf:"bytes,2,req,name=echoed_session_id"`
+}
+
+func (m *PingResponse) Reset() {
+	m = &PingResponse{}
+}
+
+func (m *PingResponse) String() string {
+	return fmt.Sprintf("pong %s %x", m.EchoedGreeting, m.EchoedSessionID)
+}
+
+func (m *PingResponse) ProtoMessage() {}
+
+// Algorithm is a deterministic wallet algorithm
+const (
+	ALGORITHM_BIP32    int32 = 0
+	ALGORITHM_ELECTRUM int32 = 1
+)
+
+// Initialize resets the device session, the session id is echoed back
+// in Features
+type Initialize struct {
+	SessionID []byte `protobuf:"bytes,1,req,name=session_id"`
+}
+
+func (m *Initialize) Reset() {
+	m = &Initialize{}
+}
+
+func (m *Initialize) String() string {
+	return fmt.Sprintf("initialize %x", m.SessionID)
+}
+
+func (m *Initialize) ProtoMessage() {}
+
+// Features is the response to Initialize
+type Features struct {
+	EchoedSessionID []byte  `protobuf:"bytes,1,req,name=echoed_session_id"`
+	Vendor          string  `protobuf:"bytes,2,opt,name=vendor"`
+	MajorVersion    uint32  `protobuf:"varint,3,opt,name=major_version"`
+	MinorVersion    uint32  `protobuf:"varint,4,opt,name=minor_version"`
+	Config          string  `protobuf:"bytes,5,opt,name=config"`
+	Otp             bool    `protobuf:"varint,6,opt,name=otp"`
+	Pin             bool    `protobuf:"varint,7,opt,name=pin"`
+	Spv             bool    `protobuf:"varint,8,opt,name=spv"`
+	Algo            []int32 `protobuf:"varint,9,rep,name=algo"`
+	DebugLink       bool    `protobuf:"varint,10,opt,name=debug_link"`
+	IsFormatted     bool    `protobuf:"varint,11,opt,name=is_formatted"`
+	DeviceName      []byte  `protobuf:"bytes,12,opt,name=device_name"`
+}
+
+func (m *Features) Reset() {
+	m = &Features{}
+}
+
+func (m *Features) String() string {
+	return fmt.Sprintf("%s %s firmware %s", m.Vendor, m.DeviceNameString(), m.Version())
+}
+
+func (m *Features) ProtoMessage() {}
+
+func (m *Features) Version() string {
+	return fmt.Sprintf("%d.%d", m.MajorVersion, m.MinorVersion)
+}
+
+func (m *Features) DeviceNameString() string {
+	nameBytes := bytes.TrimRight(m.DeviceName, string([]byte{0x00}))
+	nameBytes = bytes.TrimSpace(nameBytes)
+	return string(nameBytes)
+}
+
+// AlgorithmNames lists the supported algorithms by name
+func (m *Features) AlgorithmNames() []string {
+	names := make([]string, 0, len(m.Algo))
+	for _, algo := range m.Algo {
+		switch algo {
+		case ALGORITHM_BIP32:
+			names = append(names, "BIP32")
+		case ALGORITHM_ELECTRUM:
+			names = append(names, "ELECTRUM")
+		default:
+			names = append(names, fmt.Sprintf("unknown (%d)", algo))
+		}
+	}
+	return names
+}
+
+// ChangeDeviceName sets the name shown in Features
+type ChangeDeviceName struct {
+	DeviceName []byte `protobuf:"bytes,1,req,name=device_name"`
+}
+
+func (m *ChangeDeviceName) Reset() {
+	m = &ChangeDeviceName{}
+}
+
+func (m *ChangeDeviceName) String() string {
+	return fmt.Sprintf("change device name: %s", bytes.TrimRight(m.DeviceName, string([]byte{0x00})))
+}
+
+func (m *ChangeDeviceName) ProtoMessage() {}
+
+// FormatWalletArea wipes every wallet on the device. The entropy pool
+// is mixed into the device's own random number generator.
+type FormatWalletArea struct {
+	InitialEntropyPool []byte `protobuf:"bytes,1,req,name=initial_entropy_pool"`
+}
+
+func (m *FormatWalletArea) Reset() {
+	m = &FormatWalletArea{}
+}
+
+func (m *FormatWalletArea) String() string {
+	return fmt.Sprintf("format wallet area: %d bytes of entropy", len(m.InitialEntropyPool))
+}
+
+func (m *FormatWalletArea) ProtoMessage() {}
+
+// ChangeEncryptionKey sets the password of the loaded wallet, leave out
+// the password to make it unencrypted
+type ChangeEncryptionKey struct {
+	Password []byte `protobuf:"bytes,1,opt,name=password"`
+}
+
+func (m *ChangeEncryptionKey) Reset() {
+	m = &ChangeEncryptionKey{}
+}
+
+func (m *ChangeEncryptionKey) String() string {
+	return fmt.Sprintf("change encryption key: encrypted %t", len(m.Password) > 0)
+}
+
+func (m *ChangeEncryptionKey) ProtoMessage() {}
+
+// GetEntropy asks the device for bytes from its hardware random number
+// generator
+type GetEntropy struct {
+	NumberOfBytes uint32 `protobuf:"varint,1,req,name=number_of_bytes"`
+}
+
+func (m *GetEntropy) Reset() {
+	m = &GetEntropy{}
+}
+
+func (m *GetEntropy) String() string {
+	return fmt.Sprintf("get %d bytes of entropy", m.NumberOfBytes)
+}
+
+func (m *GetEntropy) ProtoMessage() {}
+
+// Entropy is the response to GetEntropy
+type Entropy struct {
+	Entropy []byte `protobuf:"bytes,1,req,name=entropy"`
+}
+
+func (m *Entropy) Reset() {
+	m = &Entropy{}
+}
+
+func (m *Entropy) String() string {
+	return fmt.Sprintf("%d bytes of entropy", len(m.Entropy))
+}
+
+func (m *Entropy) ProtoMessage() {}
+
+// Bulk is the contents of the device's bulk storage area, the response
+// to GetBulk
+type Bulk struct {
+	Bulk []byte `protobuf:"bytes,1,req,name=bulk"`
+}
+
+func (m *Bulk) Reset() {
+	m = &Bulk{}
+}
+
+func (m *Bulk) String() string {
+	return fmt.Sprintf("%d bytes of bulk data", len(m.Bulk))
+}
+
+func (m *Bulk) ProtoMessage() {}
+
+// SetBulk replaces the contents of the bulk storage area
+type SetBulk struct {
+	Bulk []byte `protobuf:"bytes,1,req,name=bulk"`
+}
+
+func (m *SetBulk) Reset() {
+	m = &SetBulk{}
+}
+
+func (m *SetBulk) String() string {
+	return fmt.Sprintf("set %d bytes of bulk data", len(m.Bulk))
+}
+
+func (m *SetBulk) ProtoMessage() {}
@@ -1,6 +1,7 @@
 package proto
 
 import (
+	"encoding/binary"
 	"fmt"
 )
 
@@ -54,3 +55,47 @@ func (m *AddressHandleExtended) String() string {
 }
 
 func (m *AddressHandleExtended) ProtoMessage() {}
+
+// Bytes encodes the handle with all three fields, which the device
+// requires. Marshaling leaves out the fields that are zero.
+func (m *AddressHandleExtended) Bytes() []byte {
+	b := make([]byte, 0, 3*(1+binary.MaxVarintLen32))
+	for i, v := range []uint32{m.Root, m.Chain, m.Index} {
+		// field i+1, wire type varint
+		b = append(b, byte(i+1)<<3)
+		varint := make([]byte, binary.MaxVarintLen32)
+		b = append(b, varint[:binary.PutUvarint(varint, uint64(v))]...)
+	}
+	return b
+}
+
+// PinAck answers a PinRequest with the password of the wallet
+type PinAck struct {
+	Password []byte `protobuf:"bytes,1,req,name=password"`
+}
+
+func (m *PinAck) Reset() {
+	m = &PinAck{}
+}
+
+func (m *PinAck) String() string {
+	// never print the password
+	return "PinAck"
+}
+
+func (m *PinAck) ProtoMessage() {}
+
+// OtpAck answers an OtpRequest with the code shown on the device
+type OtpAck struct {
+	Otp string `protobuf:"bytes,1,req,name=otp"`
+}
+
+func (m *OtpAck) Reset() {
+	m = &OtpAck{}
+}
+
+func (m *OtpAck) String() string {
+	return "OtpAck"
+}
+
+func (m *OtpAck) ProtoMessage() {}
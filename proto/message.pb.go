@@ -40,3 +40,55 @@ func (m *SignatureComplete) Base64Bytes() []byte {
 }
 
 func (m *SignatureComplete) ProtoMessage() {}
+
+// SignTransactionExtended asks the device to sign every input of a
+// transaction, each with the key of the matching address handle. The
+// handles are AddressHandleExtended messages encoded with Bytes, so
+// that a zero root, chain or index is still sent.
+type SignTransactionExtended struct {
+	Handles         [][]byte `protobuf:"bytes,1,rep,name=address_handle_extended"`
+	TransactionData []byte   `protobuf:"bytes,2,req,name=transaction_data"`
+	ChangeAddress   []byte   `protobuf:"bytes,3,opt,name=change_address"`
+}
+
+func (m *SignTransactionExtended) Reset() {
+	m = &SignTransactionExtended{}
+}
+
+func (m *SignTransactionExtended) String() string {
+	return fmt.Sprintf("sign transaction: %d inputs, %d bytes", len(m.Handles), len(m.TransactionData))
+}
+
+func (m *SignTransactionExtended) ProtoMessage() {}
+
+// SignatureCompleteData is one DER signature of a signed transaction
+type SignatureCompleteData struct {
+	Signature []byte `protobuf:"bytes,1,req,name=signature_data_complete"`
+}
+
+func (m *SignatureCompleteData) Reset() {
+	m = &SignatureCompleteData{}
+}
+
+func (m *SignatureCompleteData) String() string {
+	return fmt.Sprintf("%x", m.Signature)
+}
+
+func (m *SignatureCompleteData) ProtoMessage() {}
+
+// TransactionSignatures is the SignatureComplete message of
+// bitlox.proto, sent in response to SignTransactionExtended with one
+// SignatureCompleteData per input, in order
+type TransactionSignatures struct {
+	Signatures []*SignatureCompleteData `protobuf:"bytes,1,rep,name=signature_complete_data"`
+}
+
+func (m *TransactionSignatures) Reset() {
+	m = &TransactionSignatures{}
+}
+
+func (m *TransactionSignatures) String() string {
+	return fmt.Sprintf("%d transaction signatures", len(m.Signatures))
+}
+
+func (m *TransactionSignatures) ProtoMessage() {}
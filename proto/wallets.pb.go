@@ -49,6 +49,16 @@ type LoadWallet struct {
 	Number uint32 `protobuf:"varint,1,opt,name=wallet_number"`
 }
 
+func (m *LoadWallet) Reset() {
+	m = &LoadWallet{}
+}
+
+func (m *LoadWallet) String() string {
+	return fmt.Sprintf("load wallet %d", m.Number)
+}
+
+func (m *LoadWallet) ProtoMessage() {}
+
 // Wallet contains the xpub info received from the bitlox
 type CurrentWalletXPUB struct {
 	Xpub []byte `protobuf:"bytes,1,req,name=xpub"`
@@ -63,3 +73,118 @@ func (m *CurrentWalletXPUB) String() string {
 }
 
 func (m *CurrentWalletXPUB) ProtoMessage() {}
+
+// NewWallet creates a wallet on the device, leave out the password for
+// an unencrypted wallet
+type NewWallet struct {
+	Number   uint32 `protobuf:"varint,1,opt,name=wallet_number"`
+	Password []byte `protobuf:"bytes,2,opt,name=password"`
+	Name     []byte `protobuf:"bytes,3,opt,name=wallet_name"`
+	IsHidden bool   `protobuf:"varint,4,opt,name=is_hidden"`
+}
+
+func (m *NewWallet) Reset() {
+	m = &NewWallet{}
+}
+
+func (m *NewWallet) String() string {
+	return fmt.Sprintf("new wallet [%d] %s", m.Number, bytes.TrimRight(m.Name, string([]byte{0x00})))
+}
+
+func (m *NewWallet) ProtoMessage() {}
+
+// RestoreWallet creates a wallet from an existing seed, new_wallet
+// carries the same fields as for a fresh wallet
+type RestoreWallet struct {
+	NewWallet *NewWallet `protobuf:"bytes,1,req,name=new_wallet"`
+	Seed      []byte     `protobuf:"bytes,2,req,name=seed"`
+}
+
+func (m *RestoreWallet) Reset() {
+	m = &RestoreWallet{}
+}
+
+func (m *RestoreWallet) String() string {
+	// never print the seed
+	return fmt.Sprintf("restore wallet: %s", m.NewWallet)
+}
+
+func (m *RestoreWallet) ProtoMessage() {}
+
+// DeleteWallet removes a wallet from the device
+type DeleteWallet struct {
+	Number uint32 `protobuf:"varint,1,opt,name=wallet_handle"`
+}
+
+func (m *DeleteWallet) Reset() {
+	m = &DeleteWallet{}
+}
+
+func (m *DeleteWallet) String() string {
+	return fmt.Sprintf("delete wallet %d", m.Number)
+}
+
+func (m *DeleteWallet) ProtoMessage() {}
+
+// ChangeWalletName renames the currently loaded wallet
+type ChangeWalletName struct {
+	Name []byte `protobuf:"bytes,1,req,name=wallet_name"`
+}
+
+func (m *ChangeWalletName) Reset() {
+	m = &ChangeWalletName{}
+}
+
+func (m *ChangeWalletName) String() string {
+	return fmt.Sprintf("change wallet name: %s", bytes.TrimRight(m.Name, string([]byte{0x00})))
+}
+
+func (m *ChangeWalletName) ProtoMessage() {}
+
+// DisplayAddressAsQR shows a receive address of the loaded wallet on
+// the device as a QR code
+type DisplayAddressAsQR struct {
+	Index uint32 `protobuf:"varint,1,opt,name=address_handle_index"`
+}
+
+func (m *DisplayAddressAsQR) Reset() {
+	m = &DisplayAddressAsQR{}
+}
+
+func (m *DisplayAddressAsQR) String() string {
+	return fmt.Sprintf("display address %d as QR", m.Index)
+}
+
+func (m *DisplayAddressAsQR) ProtoMessage() {}
+
+// BackupWallet starts a backup of the loaded wallet on the device
+type BackupWallet struct {
+	IsEncrypted bool   `protobuf:"varint,1,opt,name=is_encrypted"`
+	Device      uint32 `protobuf:"varint,2,opt,name=device"`
+}
+
+func (m *BackupWallet) Reset() {
+	m = &BackupWallet{}
+}
+
+func (m *BackupWallet) String() string {
+	return fmt.Sprintf("backup wallet: encrypted %t device %d", m.IsEncrypted, m.Device)
+}
+
+func (m *BackupWallet) ProtoMessage() {}
+
+// MasterPublicKey is the response to a get master public key command
+type MasterPublicKey struct {
+	PublicKey []byte `protobuf:"bytes,1,req,name=public_key"`
+	ChainCode []byte `protobuf:"bytes,2,req,name=chain_code"`
+}
+
+func (m *MasterPublicKey) Reset() {
+	m = &MasterPublicKey{}
+}
+
+func (m *MasterPublicKey) String() string {
+	return fmt.Sprintf("master public key: %x chain code: %x", m.PublicKey, m.ChainCode)
+}
+
+func (m *MasterPublicKey) ProtoMessage() {}
@@ -0,0 +1,100 @@
+package bitlox
+
+import (
+	"github.com/btcsuite/btcd/txscript"
+
+	"bitlox/psbt"
+	"bitlox/wallet"
+
+	"bytes"
+	"context"
+	"errors"
+)
+
+var ERR_NOTHING_TO_SIGN = errors.New("PSBT has no inputs from the loaded wallet")
+var ERR_SIGHASH_TYPE = errors.New("The device only signs with SIGHASH_ALL")
+var ERR_NOT_P2PKH = errors.New("The device only signs inputs spending P2PKH outputs")
+
+// SignPSBT has the loaded wallet, scanned into w, sign the inputs of p
+// it owns, as told by their BIP32 derivations, and adds the signatures
+// to p. It returns how many inputs were signed.
+func SignPSBT(ctx context.Context, d *Device, w *wallet.Wallet, p *psbt.Packet) (int, error) {
+	inputs := make([]*signingInput, 0)
+	for i, in := range p.Inputs {
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			continue
+		}
+		a, err := w.FindAddress(in.Bip32Derivation)
+		if err != nil {
+			return 0, err
+		}
+		if a == nil {
+			continue
+		}
+		if in.SighashType != 0 && in.SighashType != uint32(txscript.SigHashAll) {
+			return 0, ERR_SIGHASH_TYPE
+		}
+		// legacy signatures do not cover the amount, so the utxo is
+		// only needed to check the input spends from the address
+		utxo, err := p.Utxo(i)
+		if err != nil && err != psbt.ERR_MISSING_UTXO {
+			return 0, err
+		}
+		if utxo != nil {
+			script, err := a.PkScript()
+			if err != nil {
+				return 0, err
+			}
+			if !bytes.Equal(utxo.PkScript, script) {
+				return 0, ERR_NOT_P2PKH
+			}
+		}
+		inputs = append(inputs, &signingInput{index: i, address: a})
+	}
+	if len(inputs) == 0 {
+		return 0, ERR_NOTHING_TO_SIGN
+	}
+
+	change, err := psbtChange(w, p)
+	if err != nil {
+		return 0, err
+	}
+	sigs, err := signInputs(ctx, d, p.UnsignedTx, inputs, change)
+	if err != nil {
+		return 0, err
+	}
+	for i, in := range inputs {
+		key, err := in.address.ECPubKey()
+		if err != nil {
+			return 0, err
+		}
+		p.Inputs[in.index].PartialSigs = append(p.Inputs[in.index].PartialSigs, &psbt.PartialSig{
+			PubKey:    key.SerializeCompressed(),
+			Signature: append(sigs[i].Serialize(), byte(txscript.SigHashAll)),
+		})
+	}
+	return len(inputs), nil
+}
+
+// psbtChange finds an output of p paying to the wallet's change chain.
+// The device hides the change output from its confirmation screen, so
+// the output script has to be checked as well as the derivation.
+func psbtChange(w *wallet.Wallet, p *psbt.Packet) (*wallet.Address, error) {
+	for i, out := range p.Outputs {
+		a, err := w.FindAddress(out.Bip32Derivation)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil || a.Chain != wallet.CHAIN_INDEX_CHANGE {
+			continue
+		}
+		script, err := a.PkScript()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(p.UnsignedTx.TxOut[i].PkScript, script) {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,173 @@
+package psbt
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"bytes"
+	"errors"
+)
+
+var ERR_MISSING_UTXO = errors.New("PSBT input does not say what it spends")
+var ERR_UTXO_MISMATCH = errors.New("PSBT input spends a different transaction than given")
+var ERR_UNSUPPORTED_SCRIPT = errors.New("Only single key P2PKH and P2WPKH inputs can be finalized")
+var ERR_MISSING_SIGNATURE = errors.New("PSBT input has no signature for its key")
+var ERR_INCOMPLETE = errors.New("PSBT has inputs that are not finalized")
+
+// Utxo is the output spent by input i, from its non-witness or witness
+// utxo
+func (p *Packet) Utxo(i int) (*wire.TxOut, error) {
+	in := p.Inputs[i]
+	prev := p.UnsignedTx.TxIn[i].PreviousOutPoint
+	if in.NonWitnessUtxo != nil {
+		if in.NonWitnessUtxo.TxHash() != prev.Hash || int(prev.Index) >= len(in.NonWitnessUtxo.TxOut) {
+			return nil, ERR_UTXO_MISMATCH
+		}
+		return in.NonWitnessUtxo.TxOut[prev.Index], nil
+	}
+	if in.WitnessUtxo != nil {
+		return in.WitnessUtxo, nil
+	}
+	return nil, ERR_MISSING_UTXO
+}
+
+// Fee is what the inputs pay over the outputs, when every input says
+// what it spends
+func (p *Packet) Fee() (int64, error) {
+	fee := int64(0)
+	for i := range p.Inputs {
+		utxo, err := p.Utxo(i)
+		if err != nil {
+			return 0, err
+		}
+		fee += utxo.Value
+	}
+	for _, out := range p.UnsignedTx.TxOut {
+		fee -= out.Value
+	}
+	return fee, nil
+}
+
+// IsComplete reports whether every input is finalized
+func (p *Packet) IsComplete() bool {
+	for _, in := range p.Inputs {
+		if in.FinalScriptSig == nil && in.FinalScriptWitness == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Finalize turns the partial signatures of each input not yet
+// finalized into its final script sig or witness. Inputs must spend
+// P2PKH or P2WPKH outputs.
+func (p *Packet) Finalize() error {
+	for i, in := range p.Inputs {
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			continue
+		}
+		if err := p.finalizeInput(i); err != nil {
+			return &FieldError{Map: "input", Index: i, Type: INPUT_PARTIAL_SIG, Err: err}
+		}
+	}
+	return nil
+}
+
+func (p *Packet) finalizeInput(i int) error {
+	in := p.Inputs[i]
+	utxo, err := p.Utxo(i)
+	if err != nil {
+		return err
+	}
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(utxo.PkScript, &chaincfg.MainNetParams)
+	if err != nil {
+		return err
+	}
+	if len(addrs) != 1 || (class != txscript.PubKeyHashTy && class != txscript.WitnessV0PubKeyHashTy) {
+		return ERR_UNSUPPORTED_SCRIPT
+	}
+
+	var sig *PartialSig
+	for _, s := range in.PartialSigs {
+		if bytes.Equal(btcutil.Hash160(s.PubKey), addrs[0].ScriptAddress()) {
+			sig = s
+		}
+	}
+	if sig == nil {
+		return ERR_MISSING_SIGNATURE
+	}
+
+	if class == txscript.PubKeyHashTy {
+		script, err := txscript.NewScriptBuilder().AddData(sig.Signature).AddData(sig.PubKey).Script()
+		if err != nil {
+			return err
+		}
+		in.FinalScriptSig = script
+	} else {
+		w := &bytes.Buffer{}
+		if err := writeWitness(w, wire.TxWitness{sig.Signature, sig.PubKey}); err != nil {
+			return err
+		}
+		in.FinalScriptWitness = w.Bytes()
+	}
+
+	// only the utxos and final scripts are kept once finalized
+	in.PartialSigs = nil
+	in.SighashType = 0
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	in.Bip32Derivation = nil
+	return nil
+}
+
+// Extract is the signed transaction of a complete PSBT
+func (p *Packet) Extract() (*wire.MsgTx, error) {
+	if !p.IsComplete() {
+		return nil, ERR_INCOMPLETE
+	}
+	tx := p.UnsignedTx.Copy()
+	for i, in := range p.Inputs {
+		tx.TxIn[i].SignatureScript = in.FinalScriptSig
+		if in.FinalScriptWitness != nil {
+			witness, err := readWitness(in.FinalScriptWitness)
+			if err != nil {
+				return nil, &FieldError{Map: "input", Index: i, Type: INPUT_FINAL_SCRIPTWITNESS, Err: err}
+			}
+			tx.TxIn[i].Witness = witness
+		}
+	}
+	return tx, nil
+}
+
+func writeWitness(w *bytes.Buffer, witness wire.TxWitness) error {
+	if err := wire.WriteVarInt(w, 0, uint64(len(witness))); err != nil {
+		return err
+	}
+	for _, item := range witness {
+		if err := wire.WriteVarBytes(w, 0, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readWitness(b []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(b)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(b)) {
+		return nil, errValueLength
+	}
+	witness := make(wire.TxWitness, n)
+	for i := range witness {
+		witness[i], err = wire.ReadVarBytes(r, 0, MAX_FIELD_SIZE, "witness item")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return witness, nil
+}
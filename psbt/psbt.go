@@ -0,0 +1,459 @@
+package psbt
+
+import (
+	"github.com/btcsuite/btcd/wire"
+
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// every PSBT starts with "psbt" and 0xff
+var MAGIC = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// key types of the global map
+const GLOBAL_UNSIGNED_TX = 0x00
+const GLOBAL_VERSION = 0xFB
+
+// key types of an input map
+const INPUT_NON_WITNESS_UTXO = 0x00
+const INPUT_WITNESS_UTXO = 0x01
+const INPUT_PARTIAL_SIG = 0x02
+const INPUT_SIGHASH_TYPE = 0x03
+const INPUT_REDEEM_SCRIPT = 0x04
+const INPUT_WITNESS_SCRIPT = 0x05
+const INPUT_BIP32_DERIVATION = 0x06
+const INPUT_FINAL_SCRIPTSIG = 0x07
+const INPUT_FINAL_SCRIPTWITNESS = 0x08
+
+// key types of an output map
+const OUTPUT_REDEEM_SCRIPT = 0x00
+const OUTPUT_WITNESS_SCRIPT = 0x01
+const OUTPUT_BIP32_DERIVATION = 0x02
+
+// the largest key or value read, well over any real transaction
+const MAX_FIELD_SIZE = 4000000
+
+var ERR_INVALID_MAGIC = errors.New("Not a PSBT")
+var ERR_MISSING_UNSIGNED_TX = errors.New("PSBT has no unsigned transaction")
+var ERR_SIGNED_TX = errors.New("PSBT transaction already has signature scripts")
+
+// FieldError is returned when parsing a key or value that is invalid
+// for its type
+type FieldError struct {
+	// "global", "input" or "output"
+	Map   string
+	Index int
+	Type  byte
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Map == "global" {
+		return fmt.Sprintf("Invalid global PSBT field 0x%02x: %s", e.Type, e.Err)
+	}
+	return fmt.Sprintf("Invalid PSBT field 0x%02x of %s %d: %s", e.Type, e.Map, e.Index, e.Err)
+}
+
+var errKeyLength = errors.New("wrong key length")
+var errValueLength = errors.New("wrong value length")
+var errDuplicate = errors.New("duplicate key")
+
+// Unknown is a key and value this package does not interpret, kept so
+// it is written back unchanged
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// Bip32Derivation says which key of a wallet pubkey is
+type Bip32Derivation struct {
+	PubKey []byte
+	// the first 4 bytes of the hash160 of the master public key, read
+	// big endian like hdkeychain's ParentFingerprint
+	Fingerprint uint32
+	Path        []uint32
+}
+
+// PartialSig is a signature for an input, with the sighash type byte
+// on the end
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+type Input struct {
+	NonWitnessUtxo     *wire.MsgTx
+	WitnessUtxo        *wire.TxOut
+	PartialSigs        []*PartialSig
+	SighashType        uint32
+	RedeemScript       []byte
+	WitnessScript      []byte
+	Bip32Derivation    []*Bip32Derivation
+	FinalScriptSig     []byte
+	FinalScriptWitness []byte
+	Unknowns           []*Unknown
+}
+
+type Output struct {
+	RedeemScript    []byte
+	WitnessScript   []byte
+	Bip32Derivation []*Bip32Derivation
+	Unknowns        []*Unknown
+}
+
+// Packet is a partially signed bitcoin transaction
+type Packet struct {
+	UnsignedTx *wire.MsgTx
+	Version    uint32
+	Unknowns   []*Unknown
+	Inputs     []*Input
+	Outputs    []*Output
+}
+
+// New starts a PSBT for tx, which must not be signed
+func New(tx *wire.MsgTx) (*Packet, error) {
+	if err := checkUnsigned(tx); err != nil {
+		return nil, err
+	}
+	p := &Packet{UnsignedTx: tx}
+	for range tx.TxIn {
+		p.Inputs = append(p.Inputs, &Input{})
+	}
+	for range tx.TxOut {
+		p.Outputs = append(p.Outputs, &Output{})
+	}
+	return p, nil
+}
+
+func checkUnsigned(tx *wire.MsgTx) error {
+	for _, in := range tx.TxIn {
+		if len(in.SignatureScript) > 0 || len(in.Witness) > 0 {
+			return ERR_SIGNED_TX
+		}
+	}
+	return nil
+}
+
+// Decode parses a PSBT either in binary or as base64 text
+func Decode(b []byte) (*Packet, error) {
+	if bytes.HasPrefix(b, MAGIC) {
+		return Parse(b)
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, ERR_INVALID_MAGIC
+	}
+	return Parse(raw)
+}
+
+// Parse reads a binary PSBT
+func Parse(b []byte) (*Packet, error) {
+	if !bytes.HasPrefix(b, MAGIC) {
+		return nil, ERR_INVALID_MAGIC
+	}
+	r := bytes.NewReader(b[len(MAGIC):])
+	p := &Packet{}
+
+	err := readMap(r, "global", 0, func(key, value []byte) error {
+		fe := &FieldError{Map: "global", Type: key[0]}
+		switch key[0] {
+		case GLOBAL_UNSIGNED_TX:
+			if len(key) != 1 {
+				fe.Err = errKeyLength
+				return fe
+			}
+			tx := &wire.MsgTx{}
+			if err := tx.DeserializeNoWitness(bytes.NewReader(value)); err != nil {
+				fe.Err = err
+				return fe
+			}
+			if err := checkUnsigned(tx); err != nil {
+				return err
+			}
+			p.UnsignedTx = tx
+		case GLOBAL_VERSION:
+			if len(key) != 1 {
+				fe.Err = errKeyLength
+				return fe
+			}
+			if len(value) != 4 {
+				fe.Err = errValueLength
+				return fe
+			}
+			p.Version = binary.LittleEndian.Uint32(value)
+		default:
+			p.Unknowns = append(p.Unknowns, &Unknown{Key: key, Value: value})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.UnsignedTx == nil {
+		return nil, ERR_MISSING_UNSIGNED_TX
+	}
+
+	for i := range p.UnsignedTx.TxIn {
+		in, err := readInput(r, i)
+		if err != nil {
+			return nil, err
+		}
+		p.Inputs = append(p.Inputs, in)
+	}
+	for i := range p.UnsignedTx.TxOut {
+		out, err := readOutput(r, i)
+		if err != nil {
+			return nil, err
+		}
+		p.Outputs = append(p.Outputs, out)
+	}
+	return p, nil
+}
+
+// readMap calls field for each key and value up to the separator,
+// refusing duplicate keys
+func readMap(r io.Reader, name string, index int, field func(key, value []byte) error) error {
+	seen := make(map[string]bool)
+	for {
+		key, err := wire.ReadVarBytes(r, 0, MAX_FIELD_SIZE, "key")
+		if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			return nil
+		}
+		value, err := wire.ReadVarBytes(r, 0, MAX_FIELD_SIZE, "value")
+		if err != nil {
+			return err
+		}
+		if seen[string(key)] {
+			return &FieldError{Map: name, Index: index, Type: key[0], Err: errDuplicate}
+		}
+		seen[string(key)] = true
+		if err := field(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+func readInput(r io.Reader, i int) (*Input, error) {
+	in := &Input{}
+	err := readMap(r, "input", i, func(key, value []byte) error {
+		fe := &FieldError{Map: "input", Index: i, Type: key[0]}
+		keyed := key[0] == INPUT_PARTIAL_SIG || key[0] == INPUT_BIP32_DERIVATION
+		if key[0] <= INPUT_FINAL_SCRIPTWITNESS && !keyed && len(key) != 1 {
+			fe.Err = errKeyLength
+			return fe
+		}
+		switch key[0] {
+		case INPUT_NON_WITNESS_UTXO:
+			tx := &wire.MsgTx{}
+			if err := tx.Deserialize(bytes.NewReader(value)); err != nil {
+				fe.Err = err
+				return fe
+			}
+			in.NonWitnessUtxo = tx
+		case INPUT_WITNESS_UTXO:
+			out, err := readTxOut(value)
+			if err != nil {
+				fe.Err = err
+				return fe
+			}
+			in.WitnessUtxo = out
+		case INPUT_PARTIAL_SIG:
+			if !pubKeyLength(len(key) - 1) {
+				fe.Err = errKeyLength
+				return fe
+			}
+			in.PartialSigs = append(in.PartialSigs, &PartialSig{PubKey: key[1:], Signature: value})
+		case INPUT_SIGHASH_TYPE:
+			if len(value) != 4 {
+				fe.Err = errValueLength
+				return fe
+			}
+			in.SighashType = binary.LittleEndian.Uint32(value)
+		case INPUT_REDEEM_SCRIPT:
+			in.RedeemScript = value
+		case INPUT_WITNESS_SCRIPT:
+			in.WitnessScript = value
+		case INPUT_BIP32_DERIVATION:
+			d, err := readDerivation(key, value)
+			if err != nil {
+				fe.Err = err
+				return fe
+			}
+			in.Bip32Derivation = append(in.Bip32Derivation, d)
+		case INPUT_FINAL_SCRIPTSIG:
+			in.FinalScriptSig = value
+		case INPUT_FINAL_SCRIPTWITNESS:
+			in.FinalScriptWitness = value
+		default:
+			in.Unknowns = append(in.Unknowns, &Unknown{Key: key, Value: value})
+		}
+		return nil
+	})
+	return in, err
+}
+
+func readOutput(r io.Reader, i int) (*Output, error) {
+	out := &Output{}
+	err := readMap(r, "output", i, func(key, value []byte) error {
+		fe := &FieldError{Map: "output", Index: i, Type: key[0]}
+		if key[0] <= OUTPUT_WITNESS_SCRIPT && len(key) != 1 {
+			fe.Err = errKeyLength
+			return fe
+		}
+		switch key[0] {
+		case OUTPUT_REDEEM_SCRIPT:
+			out.RedeemScript = value
+		case OUTPUT_WITNESS_SCRIPT:
+			out.WitnessScript = value
+		case OUTPUT_BIP32_DERIVATION:
+			d, err := readDerivation(key, value)
+			if err != nil {
+				fe.Err = err
+				return fe
+			}
+			out.Bip32Derivation = append(out.Bip32Derivation, d)
+		default:
+			out.Unknowns = append(out.Unknowns, &Unknown{Key: key, Value: value})
+		}
+		return nil
+	})
+	return out, err
+}
+
+func pubKeyLength(n int) bool {
+	return n == 33 || n == 65
+}
+
+func readTxOut(value []byte) (*wire.TxOut, error) {
+	if len(value) < 8 {
+		return nil, errValueLength
+	}
+	r := bytes.NewReader(value[8:])
+	script, err := wire.ReadVarBytes(r, 0, MAX_FIELD_SIZE, "script")
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errValueLength
+	}
+	return wire.NewTxOut(int64(binary.LittleEndian.Uint64(value)), script), nil
+}
+
+func readDerivation(key, value []byte) (*Bip32Derivation, error) {
+	if !pubKeyLength(len(key) - 1) {
+		return nil, errKeyLength
+	}
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, errValueLength
+	}
+	d := &Bip32Derivation{PubKey: key[1:], Fingerprint: binary.BigEndian.Uint32(value)}
+	for i := 4; i < len(value); i += 4 {
+		d.Path = append(d.Path, binary.LittleEndian.Uint32(value[i:]))
+	}
+	return d, nil
+}
+
+// Serialize writes the PSBT in binary
+func (p *Packet) Serialize() ([]byte, error) {
+	w := &bytes.Buffer{}
+	w.Write(MAGIC)
+
+	tx := &bytes.Buffer{}
+	if err := p.UnsignedTx.SerializeNoWitness(tx); err != nil {
+		return nil, err
+	}
+	writeField(w, []byte{GLOBAL_UNSIGNED_TX}, tx.Bytes())
+	if p.Version > 0 {
+		writeField(w, []byte{GLOBAL_VERSION}, uint32Bytes(p.Version))
+	}
+	writeUnknowns(w, p.Unknowns)
+	w.WriteByte(0x00)
+
+	for _, in := range p.Inputs {
+		if in.NonWitnessUtxo != nil {
+			b := &bytes.Buffer{}
+			if err := in.NonWitnessUtxo.Serialize(b); err != nil {
+				return nil, err
+			}
+			writeField(w, []byte{INPUT_NON_WITNESS_UTXO}, b.Bytes())
+		}
+		if in.WitnessUtxo != nil {
+			b := &bytes.Buffer{}
+			binary.Write(b, binary.LittleEndian, in.WitnessUtxo.Value)
+			wire.WriteVarBytes(b, 0, in.WitnessUtxo.PkScript)
+			writeField(w, []byte{INPUT_WITNESS_UTXO}, b.Bytes())
+		}
+		for _, sig := range in.PartialSigs {
+			writeField(w, append([]byte{INPUT_PARTIAL_SIG}, sig.PubKey...), sig.Signature)
+		}
+		if in.SighashType > 0 {
+			writeField(w, []byte{INPUT_SIGHASH_TYPE}, uint32Bytes(in.SighashType))
+		}
+		writeOptional(w, INPUT_REDEEM_SCRIPT, in.RedeemScript)
+		writeOptional(w, INPUT_WITNESS_SCRIPT, in.WitnessScript)
+		writeDerivations(w, INPUT_BIP32_DERIVATION, in.Bip32Derivation)
+		writeOptional(w, INPUT_FINAL_SCRIPTSIG, in.FinalScriptSig)
+		writeOptional(w, INPUT_FINAL_SCRIPTWITNESS, in.FinalScriptWitness)
+		writeUnknowns(w, in.Unknowns)
+		w.WriteByte(0x00)
+	}
+
+	for _, out := range p.Outputs {
+		writeOptional(w, OUTPUT_REDEEM_SCRIPT, out.RedeemScript)
+		writeOptional(w, OUTPUT_WITNESS_SCRIPT, out.WitnessScript)
+		writeDerivations(w, OUTPUT_BIP32_DERIVATION, out.Bip32Derivation)
+		writeUnknowns(w, out.Unknowns)
+		w.WriteByte(0x00)
+	}
+	return w.Bytes(), nil
+}
+
+// Base64 is the PSBT as base64 text, the usual way to pass one around
+func (p *Packet) Base64() (string, error) {
+	b, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func writeField(w *bytes.Buffer, key, value []byte) {
+	wire.WriteVarBytes(w, 0, key)
+	wire.WriteVarBytes(w, 0, value)
+}
+
+func writeOptional(w *bytes.Buffer, keyType byte, value []byte) {
+	if value != nil {
+		writeField(w, []byte{keyType}, value)
+	}
+}
+
+func writeUnknowns(w *bytes.Buffer, unknowns []*Unknown) {
+	for _, u := range unknowns {
+		writeField(w, u.Key, u.Value)
+	}
+}
+
+func writeDerivations(w *bytes.Buffer, keyType byte, ds []*Bip32Derivation) {
+	for _, d := range ds {
+		value := make([]byte, 4, 4+4*len(d.Path))
+		binary.BigEndian.PutUint32(value, d.Fingerprint)
+		for _, index := range d.Path {
+			value = append(value, uint32Bytes(index)...)
+		}
+		writeField(w, append([]byte{keyType}, d.PubKey...), value)
+	}
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	return b
+}
@@ -0,0 +1,122 @@
+package bitlox_test
+
+import (
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"bitlox"
+	"bitlox/btcinfo"
+	"bitlox/simulator"
+	"bitlox/wallet"
+
+	"context"
+	"encoding/hex"
+	"testing"
+)
+
+// scannedWallet opens a simulator with one wallet, loads it and scans
+// it the way bitlox-cli does
+func scannedWallet(t *testing.T) (*bitlox.Device, *wallet.Wallet) {
+	ctx := context.Background()
+	sim, err := simulator.New(simulator.TestSeed, "Test wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := bitlox.Open(ctx, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bitlox.LoadWallet(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+	xpub, err := bitlox.ScanWallet(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, wallet.WalletFromXpub(xpub)
+}
+
+// fund has mock pay value to a in a new transaction
+func fund(t *testing.T, mock *btcinfo.Mock, a *wallet.Address, value btcinfo.Satoshi) *wire.MsgTx {
+	script, err := a.PkScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(len(mock.Transactions))}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(value), script))
+	if err := mock.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mock.Addresses[a.String()] = &btcinfo.Address{Received: value, Balance: value}
+	mock.Unspent[a.String()] = []*btcinfo.Output{{
+		HashStr:   tx.TxHash().String(),
+		Value:     value,
+		ScriptStr: hex.EncodeToString(script),
+	}}
+	return tx
+}
+
+// mockChain funds the first receive address of w and makes the mock
+// the backend until the test ends
+func mockChain(t *testing.T, w *wallet.Wallet, value btcinfo.Satoshi) *btcinfo.Mock {
+	a, err := w.ReceiveAddress(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := btcinfo.NewMock()
+	fund(t, mock, a, value)
+	btcinfo.SetBackend(mock)
+	t.Cleanup(func() { btcinfo.SetBackend(&btcinfo.Toshi{}) })
+	w.LoadBalance()
+	return mock
+}
+
+// TestSignPSBT spends receive index 0 with change to change index 0,
+// the handles a zero field could drop
+func TestSignPSBT(t *testing.T) {
+	d, w := scannedWallet(t)
+	defer d.Close()
+	mockChain(t, w, 100000)
+	to, err := w.ReceiveAddress(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spend, err := w.NewSpend(to.String(), 50000, wallet.LargestFirst, &wallet.SelectOptions{FeeRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spend.Change == nil || spend.Change.ChainIndex != 0 {
+		t.Fatalf("expected change to change index 0")
+	}
+	p, err := w.NewPSBT(spend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := bitlox.SignPSBT(context.Background(), d, w, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("signed %d inputs, expected 1", n)
+	}
+	if err := p.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxo, err := p.Utxo(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := txscript.NewEngine(utxo.PkScript, tx, 0, txscript.StandardVerifyFlags, nil, nil, utxo.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+package bitlox
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"bitlox/hid"
+	"bitlox/logger"
+	models "bitlox/proto"
+
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// the oldest firmware this package knows how to talk to. Both version
+// fields of Features are optional, so 0.0 is what firmware that does
+// not report its version gives.
+const MIN_MAJOR_VERSION = 0
+const MIN_MINOR_VERSION = 1
+
+const SESSION_ID_LENGTH = 16
+
+var ERR_SESSION_MISMATCH = errors.New("Device session id does not match, the device may have been reset")
+var ERR_PING_MISMATCH = errors.New("Device did not echo the ping greeting")
+
+// UnsupportedFirmwareError is returned when connecting to a device
+// whose firmware is older than MIN_MAJOR_VERSION.MIN_MINOR_VERSION
+type UnsupportedFirmwareError struct {
+	Major uint32
+	Minor uint32
+}
+
+func (e *UnsupportedFirmwareError) Error() string {
+	return fmt.Sprintf("Unsupported firmware %d.%d, at least %d.%d is required",
+		e.Major, e.Minor, MIN_MAJOR_VERSION, MIN_MINOR_VERSION)
+}
+
+// Open wraps t in a Device and starts a session on it with Connect
+func Open(ctx context.Context, t hid.Transport) (*Device, error) {
+	d := NewDevice(t)
+	if _, err := Connect(ctx, d); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// recordedSession is implemented by transports that replay a recorded
+// session, such as hid.Replay
+type recordedSession interface {
+	RecordedWrite(cmd byte) []byte
+}
+
+// Connect starts a new session with a random session id and checks
+// the device firmware is supported. This unloads any loaded wallet.
+// When replaying a trace the recorded session id is used instead, as
+// that is the one the recorded device echoes.
+func Connect(ctx context.Context, d *Device) (*models.Features, error) {
+	sessionID, err := newSessionID(d)
+	if err != nil {
+		return nil, err
+	}
+	features, err := Initialize(ctx, d, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckFirmware(features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+func newSessionID(d *Device) ([]byte, error) {
+	if r, ok := d.t.(recordedSession); ok {
+		if payload := r.RecordedWrite(hid.PREFIX_INITIALIZE[1]); payload != nil {
+			m := &models.Initialize{}
+			if err := proto.Unmarshal(payload, m); err == nil && m.SessionID != nil {
+				return m.SessionID, nil
+			}
+		}
+	}
+	sessionID := make([]byte, SESSION_ID_LENGTH)
+	if _, err := rand.Read(sessionID); err != nil {
+		return nil, err
+	}
+	return sessionID, nil
+}
+
+// CheckFirmware returns an *UnsupportedFirmwareError if the device
+// reporting features is too old
+func CheckFirmware(features *models.Features) error {
+	if features.MajorVersion < MIN_MAJOR_VERSION ||
+		(features.MajorVersion == MIN_MAJOR_VERSION && features.MinorVersion < MIN_MINOR_VERSION) {
+		return &UnsupportedFirmwareError{Major: features.MajorVersion, Minor: features.MinorVersion}
+	}
+	return nil
+}
+
+// Initialize resets the device session and returns the features the
+// device reports
+func Initialize(ctx context.Context, d *Device, sessionID []byte) (*models.Features, error) {
+	m := &models.Initialize{SessionID: sessionID}
+
+	features := &models.Features{}
+
+	err := callExpect(ctx, d, hid.PREFIX_INITIALIZE, m, hid.RESPONSE_FEATURES, features)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("initialize got", features)
+
+	if !bytes.Equal(features.EchoedSessionID, sessionID) {
+		return nil, ERR_SESSION_MISMATCH
+	}
+
+	d.sessionID = sessionID
+	d.features = features
+	d.wallet = -1
+
+	return features, nil
+}
+
+// SessionID is the id sent in the last Initialize, nil before that
+func (d *Device) SessionID() []byte {
+	return d.sessionID
+}
+
+// Features are the features reported by the last Initialize, nil
+// before that
+func (d *Device) Features() *models.Features {
+	return d.features
+}
+
+// Ping checks the device is alive and still in the session started by
+// the last Initialize. It returns ERR_SESSION_MISMATCH if the device
+// has been reset since.
+func Ping(ctx context.Context, d *Device) error {
+	pong := &models.PingResponse{}
+
+	err := callExpect(ctx, d, hid.COMMAND_PING, nil, hid.RESPONSE_PING, pong)
+	if err != nil {
+		return err
+	}
+	logger.Debug("ping got", pong)
+
+	if pong.EchoedGreeting != hid.PING_GREETING {
+		return ERR_PING_MISMATCH
+	}
+	if d.sessionID != nil && !bytes.Equal(pong.EchoedSessionID, d.sessionID) {
+		return ERR_SESSION_MISMATCH
+	}
+	return nil
+}
+
+// EnsureSession pings the device and, if it was reset mid-session,
+// starts a new session and loads the wallet that was loaded before.
+// It reports whether the session had to be re-established.
+func EnsureSession(ctx context.Context, d *Device) (bool, error) {
+	err := Ping(ctx, d)
+	if err != ERR_SESSION_MISMATCH {
+		return false, err
+	}
+	logger.Warn("Device was reset, starting a new session")
+	wallet := d.wallet
+	if _, err := Connect(ctx, d); err != nil {
+		return true, err
+	}
+	if wallet >= 0 {
+		if err := LoadWallet(ctx, d, byte(wallet)); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,58 @@
+package bitlox_test
+
+import (
+	"bitlox"
+	models "bitlox/proto"
+	"bitlox/simulator"
+
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckFirmware(t *testing.T) {
+	tests := []struct {
+		major, minor uint32
+		ok           bool
+	}{
+		{0, 0, false},
+		{bitlox.MIN_MAJOR_VERSION, bitlox.MIN_MINOR_VERSION, true},
+		{bitlox.MIN_MAJOR_VERSION, bitlox.MIN_MINOR_VERSION + 1, true},
+		{bitlox.MIN_MAJOR_VERSION + 1, 0, true},
+	}
+	for _, test := range tests {
+		err := bitlox.CheckFirmware(&models.Features{MajorVersion: test.major, MinorVersion: test.minor})
+		if (err == nil) != test.ok {
+			t.Errorf("%d.%d: got %v", test.major, test.minor, err)
+		}
+	}
+}
+
+func TestOpenRefusesOldFirmware(t *testing.T) {
+	sim, err := simulator.New(simulator.TestSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.MajorVersion, sim.MinorVersion = 0, 0
+
+	_, err = bitlox.Open(context.Background(), sim)
+	var unsupported *bitlox.UnsupportedFirmwareError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("expected an UnsupportedFirmwareError, got %v", err)
+	}
+	if unsupported.Major != 0 || unsupported.Minor != 0 {
+		t.Errorf("error reports firmware %d.%d", unsupported.Major, unsupported.Minor)
+	}
+}
+
+func TestOpenSimulator(t *testing.T) {
+	sim, err := simulator.New(simulator.TestSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := bitlox.Open(context.Background(), sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+}
@@ -0,0 +1,91 @@
+package simulator
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"bitlox/hid"
+	models "bitlox/proto"
+)
+
+// the size of the simulated bulk storage area
+const BULK_SIZE = 8192
+
+func (s *Simulator) changeDeviceName(payload []byte) {
+	m := &models.ChangeDeviceName{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	s.confirm(func() (byte, proto.Message) {
+		s.DeviceName = decodeName(m.DeviceName)
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
+
+// resetLang and resetPIN only need the button press, the menus they
+// bring up on a real device are not emulated
+func (s *Simulator) resetLang() {
+	s.confirm(func() (byte, proto.Message) {
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
+
+func (s *Simulator) resetPIN() {
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	s.confirm(func() (byte, proto.Message) {
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
+
+func (s *Simulator) format(payload []byte) {
+	m := &models.FormatWalletArea{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	s.guard(func() {
+		s.confirm(func() (byte, proto.Message) {
+			s.wallets = make(map[uint32]*simWallet)
+			s.loaded = nil
+			return hid.RESPONSE_SUCCESS, &models.Success{}
+		})
+	})
+}
+
+func (s *Simulator) changeEncryptionKey(payload []byte) {
+	m := &models.ChangeEncryptionKey{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	w := s.loaded
+	s.guard(func() {
+		s.confirm(func() (byte, proto.Message) {
+			w.password = m.Password
+			return hid.RESPONSE_SUCCESS, &models.Success{}
+		})
+	})
+}
+
+func (s *Simulator) setBulk(payload []byte) {
+	m := &models.SetBulk{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	if len(m.Bulk) > BULK_SIZE {
+		s.fail(FAILURE_INVALID_MESSAGE, "Bulk data too large")
+		return
+	}
+	s.confirm(func() (byte, proto.Message) {
+		s.bulk = m.Bulk
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
@@ -0,0 +1,473 @@
+// Package simulator is an in-process stand in for a BitLox. It speaks
+// the same report framing as the hardware and can be handed to
+// bitlox.NewDevice anywhere a hid.Transport is expected.
+package simulator
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"bitlox/hid"
+	"bitlox/logger"
+	models "bitlox/proto"
+
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// TestSeed is the seed of BIP32 test vector 1, used for the default
+// wallets so addresses are reproducible between runs
+var TestSeed, _ = hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+var ERR_NO_RESPONSE = errors.New("Simulator has no response queued")
+var ERR_CLOSED = errors.New("Simulator is closed")
+
+// failure codes returned in models.Failure. They are the simulator's
+// own, the host does not depend on their values.
+const (
+	FAILURE_UNKNOWN_COMMAND uint32 = iota + 1
+	FAILURE_INVALID_MESSAGE
+	FAILURE_NO_WALLET
+	FAILURE_UNEXPECTED_ACK
+	FAILURE_WALLET_EXISTS
+	FAILURE_CANCELLED
+	FAILURE_INVALID_OTP
+	FAILURE_WRONG_PASSWORD
+)
+
+// the firmware version the simulator reports unless told otherwise
+const FIRMWARE_MAJOR_VERSION = 0
+const FIRMWARE_MINOR_VERSION = 1
+
+// the length of the zeroed session id reported before any Initialize
+const SESSION_ID_LENGTH = 16
+
+// Simulator holds the state of one emulated device
+type Simulator struct {
+	// reported in Features, change them to emulate other firmware
+	MajorVersion uint32
+	MinorVersion uint32
+	DeviceName   string
+	// RequireOtp makes destructive commands ask for a one time password,
+	// which is logged as the device would show it on its screen
+	RequireOtp bool
+	// HoldButton makes an acknowledged button request wait for
+	// PressButton, the way a device waits for its button, instead of
+	// going ahead at once
+	HoldButton bool
+
+	mu      sync.Mutex
+	seed    []byte
+	wallets map[uint32]*simWallet
+	bulk    []byte
+	loaded  *simWallet
+	dec     *hid.Decoder
+	out     [][]byte
+	pending func() (byte, proto.Message)
+	held    bool
+	otp     string
+	otpNext func()
+	pinNext func(password []byte)
+	closed  bool
+
+	sessionID []byte
+}
+
+// New makes a simulator whose wallets are derived from seed, one per
+// name given
+func New(seed []byte, names ...string) (*Simulator, error) {
+	s := &Simulator{
+		MajorVersion: FIRMWARE_MAJOR_VERSION,
+		MinorVersion: FIRMWARE_MINOR_VERSION,
+		DeviceName:   "Simulated BitLox",
+		seed:         seed,
+		wallets:      make(map[uint32]*simWallet),
+		dec:          &hid.Decoder{MaxPayload: hid.DEFAULT_MAX_FRAME_PAYLOAD, Terminated: true},
+	}
+	for _, name := range names {
+		if err := s.AddWallet(name); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// UUID is the device UUID reported by the simulator
+func (s *Simulator) UUID() []byte {
+	uuid := sha256.Sum256(s.seed)
+	return uuid[0:16]
+}
+
+// Reset emulates the device power cycling: the session is forgotten
+// and the loaded wallet is unloaded
+func (s *Simulator) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionID = nil
+	s.loaded = nil
+	s.pending = nil
+	s.held = false
+	s.otpNext = nil
+	s.pinNext = nil
+	s.out = nil
+	s.dec.Reset()
+}
+
+func (s *Simulator) WriteFrame(report []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ERR_CLOSED
+	}
+	// drop the report byte, the rest is stream data
+	_, err := s.dec.Write(bytes.TrimPrefix(report, hid.BITLOX_REPORT))
+	s.process()
+	if err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+	}
+	return nil
+}
+
+// ReadFrame only blocks while a held button request waits for
+// PressButton: otherwise the simulator answers every request as soon
+// as it is written, so having nothing queued is an error.
+func (s *Simulator) ReadFrame(report []byte, timeout time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ERR_CLOSED
+	}
+	if len(s.out) == 0 && s.held {
+		s.mu.Unlock()
+		time.Sleep(timeout)
+		s.mu.Lock()
+		return 0, nil
+	}
+	if len(s.out) == 0 {
+		return 0, ERR_NO_RESPONSE
+	}
+	n := copy(report, s.out[0])
+	s.out = s.out[1:]
+	return n, nil
+}
+
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// process handles every complete frame decoded so far
+func (s *Simulator) process() {
+	for f := s.dec.Next(); f != nil; f = s.dec.Next() {
+		if hid.Sensitive([]byte{0x00, f.Command}) {
+			logger.Debugf("simulator got: cmd: %#04x size: %d\n", f.Command, len(f.Payload))
+		} else {
+			logger.Debugf("simulator got: cmd: %#04x payload: %x\n", f.Command, f.Payload)
+		}
+		s.handle(f.Command, f.Payload)
+	}
+}
+
+func (s *Simulator) handle(cmd byte, payload []byte) {
+	switch cmd {
+	case hid.PREFIX_INITIALIZE[1]:
+		s.initialize(payload)
+	case hid.COMMAND_PING[1]:
+		s.ping(payload)
+	case hid.COMMAND_LIST_WALLETS[1]:
+		s.listWallets()
+	case hid.PREFIX_LOAD_WALLET[1]:
+		s.loadWallet(payload)
+	case hid.COMMAND_SCAN_WALLET[1]:
+		s.scanWallet()
+	case hid.PREFIX_SIGN_MESSAGE[1]:
+		s.signMessage(payload)
+	case hid.PREFIX_SIGN_TRANSACTION[1]:
+		s.signTransaction(payload)
+	case hid.PREFIX_DISPLAY_ADDRESS_QR[1]:
+		s.displayAddress(payload)
+	case hid.PREFIX_NEW_WALLET[1]:
+		s.newWallet(payload)
+	case hid.PREFIX_RESTORE_WALLET[1]:
+		s.restoreWallet(payload)
+	case hid.PREFIX_DELETE_WALLET[1]:
+		s.deleteWallet(payload)
+	case hid.PREFIX_RENAME_WALLET[1]:
+		s.renameWallet(payload)
+	case hid.PREFIX_CHANGE_DEVICE_NAME[1]:
+		s.changeDeviceName(payload)
+	case hid.COMMAND_RESET_LANG[1]:
+		s.resetLang()
+	case hid.COMMAND_RESET_PIN[1]:
+		s.resetPIN()
+	case hid.PREFIX_FORMAT[1]:
+		s.format(payload)
+	case hid.PREFIX_CHANGE_ENCRYPTION_KEY[1]:
+		s.changeEncryptionKey(payload)
+	case hid.COMMAND_GET_MASTER_PUBLIC_KEY[1]:
+		s.getMasterPublicKey()
+	case hid.PREFIX_BACKUP_WALLET[1]:
+		s.backupWallet(payload)
+	case hid.COMMAND_GET_BULK[1]:
+		s.respond(hid.RESPONSE_BULK, &models.Bulk{Bulk: s.bulk})
+	case hid.PREFIX_SET_BULK[1]:
+		s.setBulk(payload)
+	case hid.PREFIX_GET_ENTROPY[1]:
+		s.getEntropy(payload)
+	case hid.COMMAND_GET_DEVICE_UUID[1]:
+		s.respond(hid.RESPONSE_DEVICE_UUID, &models.DeviceUUID{UUID: s.UUID()})
+	case hid.COMMAND_ACK[1]:
+		s.ack()
+	case hid.COMMAND_BUTTON_CANCEL[1]:
+		s.cancel()
+	case hid.PREFIX_OTP_ACK[1]:
+		s.otpAck(payload)
+	case hid.COMMAND_OTP_CANCEL[1]:
+		s.otpCancel()
+	case hid.PREFIX_PIN_ACK[1]:
+		s.pinAck(payload)
+	case hid.COMMAND_PIN_CANCEL[1]:
+		s.pinCancel()
+	default:
+		s.fail(FAILURE_UNKNOWN_COMMAND, "Unknown command")
+	}
+}
+
+func (s *Simulator) initialize(payload []byte) {
+	m := &models.Initialize{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	s.sessionID = m.SessionID
+	s.loaded = nil
+	s.pending = nil
+	s.otpNext = nil
+	s.pinNext = nil
+	name := make([]byte, WALLET_NAME_LENGTH)
+	copy(name, s.DeviceName)
+	s.respond(hid.RESPONSE_FEATURES, &models.Features{
+		EchoedSessionID: m.SessionID,
+		Vendor:          "BitLox",
+		MajorVersion:    s.MajorVersion,
+		MinorVersion:    s.MinorVersion,
+		Config:          "simulator",
+		Algo:            []int32{models.ALGORITHM_BIP32},
+		IsFormatted:     true,
+		DeviceName:      name,
+	})
+}
+
+func (s *Simulator) getEntropy(payload []byte) {
+	m := &models.GetEntropy{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	entropy := make([]byte, m.NumberOfBytes)
+	if _, err := rand.Read(entropy); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	s.respond(hid.RESPONSE_ENTROPY, &models.Entropy{Entropy: entropy})
+}
+
+func (s *Simulator) ping(payload []byte) {
+	m := &models.Ping{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	sessionID := s.sessionID
+	if sessionID == nil {
+		sessionID = make([]byte, SESSION_ID_LENGTH)
+	}
+	s.respond(hid.RESPONSE_PING, &models.PingResponse{
+		EchoedGreeting:  m.Greeting,
+		EchoedSessionID: sessionID,
+	})
+}
+
+// confirm answers with a button request and runs fn once the host
+// acknowledges it
+func (s *Simulator) confirm(fn func() (byte, proto.Message)) {
+	s.pending = fn
+	s.respond(hid.RESPONSE_PLEASE_ACK, nil)
+}
+
+func (s *Simulator) ack() {
+	if s.pending == nil || s.held {
+		s.fail(FAILURE_UNEXPECTED_ACK, "Nothing to acknowledge")
+		return
+	}
+	if s.HoldButton {
+		s.held = true
+		logger.Log("[simulator screen] Press the button to continue")
+		return
+	}
+	cmd, m := s.pending()
+	s.pending = nil
+	s.respond(cmd, m)
+}
+
+func (s *Simulator) cancel() {
+	if s.pending == nil {
+		s.fail(FAILURE_UNEXPECTED_ACK, "Nothing to cancel")
+		return
+	}
+	s.pending = nil
+	s.held = false
+	s.fail(FAILURE_CANCELLED, "Cancelled")
+}
+
+// PressButton presses the button an acknowledged request waits for
+// when HoldButton is set. It does nothing if nothing is waiting.
+func (s *Simulator) PressButton() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.held {
+		return
+	}
+	s.held = false
+	cmd, m := s.pending()
+	s.pending = nil
+	s.respond(cmd, m)
+}
+
+// guard runs next straight away, or once the host enters the right
+// one time password if RequireOtp is set
+func (s *Simulator) guard(next func()) {
+	if !s.RequireOtp {
+		next()
+		return
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	s.otp = fmt.Sprintf("%04d", n)
+	s.otpNext = next
+	logger.Logf("[simulator screen] OTP: %s\n", s.otp)
+	s.respond(hid.RESPONSE_OTP_REQUEST, nil)
+}
+
+func (s *Simulator) otpAck(payload []byte) {
+	if s.otpNext == nil {
+		s.fail(FAILURE_UNEXPECTED_ACK, "No OTP requested")
+		return
+	}
+	m := &models.OtpAck{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	next := s.otpNext
+	s.otpNext = nil
+	if m.Otp != s.otp {
+		s.fail(FAILURE_INVALID_OTP, "Wrong OTP")
+		return
+	}
+	next()
+}
+
+func (s *Simulator) otpCancel() {
+	if s.otpNext == nil {
+		s.fail(FAILURE_UNEXPECTED_ACK, "Nothing to cancel")
+		return
+	}
+	s.otpNext = nil
+	s.fail(FAILURE_CANCELLED, "Cancelled")
+}
+
+// askPin asks the host for a password and passes it to next
+func (s *Simulator) askPin(next func(password []byte)) {
+	s.pinNext = next
+	s.respond(hid.RESPONSE_PIN_REQUEST, nil)
+}
+
+func (s *Simulator) pinAck(payload []byte) {
+	if s.pinNext == nil {
+		s.fail(FAILURE_UNEXPECTED_ACK, "No password requested")
+		return
+	}
+	m := &models.PinAck{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	next := s.pinNext
+	s.pinNext = nil
+	next(m.Password)
+}
+
+func (s *Simulator) pinCancel() {
+	if s.pinNext == nil {
+		s.fail(FAILURE_UNEXPECTED_ACK, "Nothing to cancel")
+		return
+	}
+	s.pinNext = nil
+	s.fail(FAILURE_CANCELLED, "Cancelled")
+}
+
+func failure(code uint32, message string) (byte, proto.Message) {
+	return hid.RESPONSE_ERROR, &models.Failure{Code: int32(code), Message: []byte(message)}
+}
+
+func (s *Simulator) fail(code uint32, message string) {
+	s.respond(failure(code, message))
+}
+
+// respond queues a frame for the host, split into reports the same
+// way the device sends them: zero padded, with at least one byte of
+// padding after the payload
+func (s *Simulator) respond(cmd byte, m proto.Message) {
+	var payload []byte
+	if m != nil {
+		var err error
+		payload, err = proto.Marshal(m)
+		if err != nil {
+			logger.Error("simulator marshal error", err)
+			return
+		}
+	}
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(payload)))
+	frame := append([]byte{}, hid.BITLOX_MAGIC...)
+	frame = append(frame, 0x00, cmd)
+	frame = append(frame, size...)
+	frame = append(frame, payload...)
+	padded := make([]byte, (len(frame)/hid.CHUNK_SIZE+1)*hid.CHUNK_SIZE)
+	copy(padded, frame)
+	for i := 0; i < len(padded); i += hid.CHUNK_SIZE {
+		s.out = append(s.out, padded[i:i+hid.CHUNK_SIZE])
+	}
+}
+
+// parseHandle decodes an address handle, which the host sends already
+// wrapped as a length delimited field
+func parseHandle(b []byte) (*models.AddressHandleExtended, error) {
+	if len(b) > 2 && b[0] == 0x0A && int(b[1]) == len(b)-2 {
+		b = b[2:]
+	}
+	handle := &models.AddressHandleExtended{}
+	if err := proto.Unmarshal(b, handle); err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+func doubleSha(b []byte) []byte {
+	round1 := sha256.Sum256(b)
+	round2 := sha256.Sum256(round1[:])
+	return round2[:]
+}
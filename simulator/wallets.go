@@ -0,0 +1,488 @@
+package simulator
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/base58"
+	bip32 "github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/golang/protobuf/proto"
+
+	"bitlox/hid"
+	"bitlox/logger"
+	models "bitlox/proto"
+
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sort"
+)
+
+const WALLET_NAME_LENGTH = 40
+
+var ERR_TX_DATA_MARKER = errors.New("Unknown transaction data marker")
+
+type simWallet struct {
+	number   uint32
+	name     string
+	password []byte
+	hidden   bool
+	master   *bip32.ExtendedKey
+}
+
+func (w *simWallet) key(root, chain, index uint32) (*bip32.ExtendedKey, error) {
+	k, err := w.master.Child(bip32.HardenedKeyStart + root)
+	if err != nil {
+		return nil, err
+	}
+	k, err = k.Child(chain)
+	if err != nil {
+		return nil, err
+	}
+	return k.Child(index)
+}
+
+func (w *simWallet) xpub() ([]byte, error) {
+	k, err := w.master.Child(bip32.HardenedKeyStart)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.Neuter()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(pub.String()), nil
+}
+
+// masterPublicKey splits the key xpub returns into the parts the
+// device sends for GetMasterPublicKey
+func (w *simWallet) masterPublicKey() (*models.MasterPublicKey, error) {
+	xpub, err := w.xpub()
+	if err != nil {
+		return nil, err
+	}
+	// version, depth, parent fingerprint and child number come first
+	raw := base58.Decode(string(xpub))
+	return &models.MasterPublicKey{PublicKey: raw[45:78], ChainCode: raw[13:45]}, nil
+}
+
+func (w *simWallet) info() (*models.WalletInfo, error) {
+	xpub, err := w.xpub()
+	if err != nil {
+		return nil, err
+	}
+	uuid := sha256.Sum256(xpub)
+	name := make([]byte, WALLET_NAME_LENGTH)
+	copy(name, w.name)
+	return &models.WalletInfo{
+		Number:  int32(w.number),
+		Name:    name,
+		UUID:    uuid[0:16],
+		Version: 1,
+	}, nil
+}
+
+// AddWallet creates the next wallet from the simulator seed
+func (s *Simulator) AddWallet(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	root, err := bip32.NewMaster(s.seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return err
+	}
+	number := s.freeNumber()
+	master, err := root.Child(bip32.HardenedKeyStart + number)
+	if err != nil {
+		return err
+	}
+	s.wallets[number] = &simWallet{number: number, name: name, master: master}
+	return nil
+}
+
+// freeNumber is the lowest wallet number not in use
+func (s *Simulator) freeNumber() uint32 {
+	number := uint32(0)
+	for _, ok := s.wallets[number]; ok; _, ok = s.wallets[number] {
+		number++
+	}
+	return number
+}
+
+func (s *Simulator) sortedWallets() []*simWallet {
+	wallets := make([]*simWallet, 0, len(s.wallets))
+	for _, w := range s.wallets {
+		wallets = append(wallets, w)
+	}
+	sort.Slice(wallets, func(i, j int) bool { return wallets[i].number < wallets[j].number })
+	return wallets
+}
+
+// decodeName strips the null terminator the host sends names with
+func decodeName(b []byte) string {
+	return string(bytes.TrimRight(b, string([]byte{0x00})))
+}
+
+func (s *Simulator) listWallets() {
+	wallets := &models.Wallets{}
+	for _, w := range s.sortedWallets() {
+		if w.hidden {
+			continue
+		}
+		info, err := w.info()
+		if err != nil {
+			s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+			return
+		}
+		wallets.Wallets = append(wallets.Wallets, info)
+	}
+	s.respond(hid.RESPONSE_WALLETS, wallets)
+}
+
+func (s *Simulator) loadWallet(payload []byte) {
+	m := &models.LoadWallet{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	w, ok := s.wallets[m.Number]
+	if !ok {
+		s.fail(FAILURE_NO_WALLET, "Invalid wallet number")
+		return
+	}
+	if len(w.password) == 0 {
+		s.loaded = w
+		s.respond(hid.RESPONSE_SUCCESS, &models.Success{})
+		return
+	}
+	s.askPin(func(password []byte) {
+		if !bytes.Equal(password, w.password) {
+			s.fail(FAILURE_WRONG_PASSWORD, "Wrong password")
+			return
+		}
+		s.loaded = w
+		s.respond(hid.RESPONSE_SUCCESS, &models.Success{})
+	})
+}
+
+func (s *Simulator) scanWallet() {
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	xpub, err := s.loaded.xpub()
+	if err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	s.respond(hid.RESPONSE_XPUB, &models.CurrentWalletXPUB{Xpub: xpub})
+}
+
+func (s *Simulator) signMessage(payload []byte) {
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	m := &models.SignMessage{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	handle, err := parseHandle(m.Handle)
+	if err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	w := s.loaded
+	s.confirm(func() (byte, proto.Message) {
+		k, err := w.key(handle.Root, handle.Chain, handle.Index)
+		if err != nil {
+			return failure(FAILURE_INVALID_MESSAGE, err.Error())
+		}
+		priv, err := k.ECPrivKey()
+		if err != nil {
+			return failure(FAILURE_INVALID_MESSAGE, err.Error())
+		}
+		// the host sends the message with the prefix and lengths
+		// already in place, ready to hash
+		sig, err := priv.Sign(doubleSha(m.Message))
+		if err != nil {
+			return failure(FAILURE_INVALID_MESSAGE, err.Error())
+		}
+		return hid.RESPONSE_MESSAGE_SIGNATURE, &models.SignatureComplete{Signature: sig.Serialize()}
+	})
+}
+
+// displayAddress logs the address where the device would show it as a
+// QR code, until the button is pressed
+func (s *Simulator) displayAddress(payload []byte) {
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	m := &models.DisplayAddressAsQR{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	k, err := s.loaded.key(0, 0, m.Index)
+	if err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	addr, err := k.Address(&chaincfg.MainNetParams)
+	if err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	logger.Logf("[simulator screen] QR: %s\n", addr.EncodeAddress())
+	s.confirm(func() (byte, proto.Message) {
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
+
+// backupWallet only needs the button press, there is nowhere for the
+// simulator to back up to
+func (s *Simulator) backupWallet(payload []byte) {
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	m := &models.BackupWallet{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	logger.Logf("[simulator screen] Backing up wallet %d\n", s.loaded.number)
+	s.confirm(func() (byte, proto.Message) {
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
+
+func (s *Simulator) getMasterPublicKey() {
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	w := s.loaded
+	s.confirm(func() (byte, proto.Message) {
+		mpk, err := w.masterPublicKey()
+		if err != nil {
+			return failure(FAILURE_INVALID_MESSAGE, err.Error())
+		}
+		return hid.RESPONSE_MASTER_PUBLIC_KEY, mpk
+	})
+}
+
+func (s *Simulator) newWallet(payload []byte) {
+	m := &models.NewWallet{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	s.createWallet(m, nil)
+}
+
+func (s *Simulator) restoreWallet(payload []byte) {
+	m := &models.RestoreWallet{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	if m.NewWallet == nil || len(m.Seed) == 0 {
+		s.fail(FAILURE_INVALID_MESSAGE, "Missing wallet or seed")
+		return
+	}
+	s.createWallet(m.NewWallet, m.Seed)
+}
+
+// createWallet adds the wallet described by m once the host confirms,
+// from seed or from a random one if seed is nil
+func (s *Simulator) createWallet(m *models.NewWallet, seed []byte) {
+	if _, ok := s.wallets[m.Number]; ok {
+		s.fail(FAILURE_WALLET_EXISTS, "Wallet already exists")
+		return
+	}
+	s.confirm(func() (byte, proto.Message) {
+		if seed == nil {
+			seed = make([]byte, 32)
+			if _, err := rand.Read(seed); err != nil {
+				return failure(FAILURE_INVALID_MESSAGE, err.Error())
+			}
+		}
+		master, err := bip32.NewMaster(seed, &chaincfg.MainNetParams)
+		if err != nil {
+			return failure(FAILURE_INVALID_MESSAGE, err.Error())
+		}
+		s.wallets[m.Number] = &simWallet{
+			number:   m.Number,
+			name:     decodeName(m.Name),
+			password: m.Password,
+			hidden:   m.IsHidden,
+			master:   master,
+		}
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
+
+func (s *Simulator) deleteWallet(payload []byte) {
+	m := &models.DeleteWallet{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	w, ok := s.wallets[m.Number]
+	if !ok {
+		s.fail(FAILURE_NO_WALLET, "No such wallet")
+		return
+	}
+	s.guard(func() {
+		s.confirm(func() (byte, proto.Message) {
+			delete(s.wallets, m.Number)
+			if s.loaded == w {
+				s.loaded = nil
+			}
+			return hid.RESPONSE_SUCCESS, &models.Success{}
+		})
+	})
+}
+
+func (s *Simulator) renameWallet(payload []byte) {
+	m := &models.ChangeWalletName{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	w := s.loaded
+	s.confirm(func() (byte, proto.Message) {
+		w.name = decodeName(m.Name)
+		return hid.RESPONSE_SUCCESS, &models.Success{}
+	})
+}
+
+// signTransaction signs each preimage in the transaction data with the
+// key of the matching handle, once the host confirms
+func (s *Simulator) signTransaction(payload []byte) {
+	if s.loaded == nil {
+		s.fail(FAILURE_NO_WALLET, "No wallet loaded")
+		return
+	}
+	m := &models.SignTransactionExtended{}
+	if err := proto.Unmarshal(payload, m); err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	preimages, err := splitPreimages(m.TransactionData)
+	if err != nil {
+		s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+		return
+	}
+	if len(preimages) != len(m.Handles) {
+		s.fail(FAILURE_INVALID_MESSAGE, "One address handle is needed per input")
+		return
+	}
+	handles := make([]*models.AddressHandleExtended, len(m.Handles))
+	for i, b := range m.Handles {
+		if handles[i], err = parseHandle(b); err != nil {
+			s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+			return
+		}
+	}
+	var change *models.AddressHandleExtended
+	if m.ChangeAddress != nil {
+		if change, err = parseHandle(m.ChangeAddress); err != nil {
+			s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+			return
+		}
+	}
+	w := s.loaded
+	if len(preimages) > 0 {
+		if err := w.showOutputs(preimages[0], change); err != nil {
+			s.fail(FAILURE_INVALID_MESSAGE, err.Error())
+			return
+		}
+	}
+	s.confirm(func() (byte, proto.Message) {
+		sigs := &models.TransactionSignatures{}
+		for i, preimage := range preimages {
+			h := handles[i]
+			k, err := w.key(h.Root, h.Chain, h.Index)
+			if err != nil {
+				return failure(FAILURE_INVALID_MESSAGE, err.Error())
+			}
+			priv, err := k.ECPrivKey()
+			if err != nil {
+				return failure(FAILURE_INVALID_MESSAGE, err.Error())
+			}
+			sig, err := priv.Sign(doubleSha(preimage))
+			if err != nil {
+				return failure(FAILURE_INVALID_MESSAGE, err.Error())
+			}
+			sigs.Signatures = append(sigs.Signatures, &models.SignatureCompleteData{Signature: sig.Serialize()})
+		}
+		return hid.RESPONSE_TX_SIGNATURES, sigs
+	})
+}
+
+// showOutputs logs the outputs of the transaction in preimage where
+// the device would ask to confirm them, all but the change output
+func (w *simWallet) showOutputs(preimage []byte, change *models.AddressHandleExtended) error {
+	tx := &wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(preimage)); err != nil {
+		return err
+	}
+	var changeScript []byte
+	if change != nil {
+		k, err := w.key(change.Root, change.Chain, change.Index)
+		if err != nil {
+			return err
+		}
+		addr, err := k.Address(&chaincfg.MainNetParams)
+		if err != nil {
+			return err
+		}
+		changeScript, err = txscript.PayToAddrScript(addr)
+		if err != nil {
+			return err
+		}
+	}
+	for _, out := range tx.TxOut {
+		if changeScript != nil && bytes.Equal(out.PkScript, changeScript) {
+			continue
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, &chaincfg.MainNetParams)
+		if err != nil || len(addrs) != 1 {
+			logger.Logf("[simulator screen] Send %d to unknown script\n", out.Value)
+			continue
+		}
+		logger.Logf("[simulator screen] Send %d to %s\n", out.Value, addrs[0].EncodeAddress())
+	}
+	return nil
+}
+
+// splitPreimages reads the transaction data the host sends: for each
+// input a marker byte, a serialized transaction and a 4 byte hash type
+func splitPreimages(data []byte) ([][]byte, error) {
+	r := bytes.NewReader(data)
+	preimages := make([][]byte, 0)
+	for r.Len() > 0 {
+		if marker, _ := r.ReadByte(); marker != 0x00 {
+			return nil, ERR_TX_DATA_MARKER
+		}
+		start := len(data) - r.Len()
+		tx := &wire.MsgTx{}
+		if err := tx.Deserialize(r); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, make([]byte, 4)); err != nil {
+			return nil, err
+		}
+		preimages = append(preimages, data[start:len(data)-r.Len()])
+	}
+	return preimages, nil
+}
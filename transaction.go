@@ -0,0 +1,212 @@
+package bitlox
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"bitlox/hid"
+	models "bitlox/proto"
+	"bitlox/wallet"
+
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// transaction_data is not described in bitlox.proto. The markers come
+// from the hardware Bitcoin wallet firmware that bitlox.proto started
+// from (its messages.proto, built with nanopb), where each transaction
+// in transaction_data follows a marker byte: TX_DATA_UNSIGNED for the
+// transaction to sign, with the script being spent in place of the
+// input script and the hash type appended, TX_DATA_SUPPORTING for the
+// transactions spent from. One unsigned transaction per handle, in
+// handle order, is this package's reading of SignTransactionExtended
+// and has only been run against the simulator.
+const TX_DATA_UNSIGNED = 0x00
+const TX_DATA_SUPPORTING = 0x01
+
+var ERR_NO_INPUTS = errors.New("Transaction has no inputs")
+var ERR_SPV_UNSUPPORTED = errors.New("Device expects supporting transactions, which are not sent yet")
+
+// SignatureCountError is returned by SignTransaction when the device
+// does not give one signature per input
+type SignatureCountError struct {
+	Inputs     int
+	Signatures int
+}
+
+func (e *SignatureCountError) Error() string {
+	return fmt.Sprintf("Device returned %d signatures for %d inputs", e.Signatures, e.Inputs)
+}
+
+// InputSignatureError is returned by SignTransaction when the
+// signature for an input does not verify
+type InputSignatureError struct {
+	Input int
+	Err   error
+}
+
+func (e *InputSignatureError) Error() string {
+	return fmt.Sprintf("Invalid signature for input %d: %s", e.Input, e.Err)
+}
+
+// SignTransaction has the loaded wallet sign every input of spend and
+// returns the transaction with its signature scripts filled in. Each
+// signature is checked against the key of its input address before the
+// transaction is returned.
+func SignTransaction(ctx context.Context, d *Device, spend *wallet.Spend) (*wire.MsgTx, error) {
+	inputs := make([]*signingInput, len(spend.Inputs))
+	for i, in := range spend.Inputs {
+		inputs[i] = &signingInput{index: i, address: in.Address}
+	}
+	sigs, err := signInputs(ctx, d, spend.Tx, inputs, spend.Change)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := spend.Tx.Copy()
+	for i, in := range spend.Inputs {
+		key, err := in.Address.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		script, err := txscript.NewScriptBuilder().
+			AddData(append(sigs[i].Serialize(), byte(txscript.SigHashAll))).
+			AddData(key.SerializeCompressed()).
+			Script()
+		if err != nil {
+			return nil, err
+		}
+		tx.TxIn[i].SignatureScript = script
+	}
+	for i, in := range spend.Inputs {
+		if err := verifyInput(tx, i, in); err != nil {
+			return nil, &InputSignatureError{Input: i, Err: err}
+		}
+	}
+	return tx, nil
+}
+
+// signingInput is an input of a transaction spending from an address
+// of the loaded wallet
+type signingInput struct {
+	index   int
+	address *wallet.Address
+}
+
+// signInputs has the device sign inputs of tx with SIGHASH_ALL and
+// returns the signatures in the same order, each checked against the
+// key of its address. change, if not nil, is the wallet address of the
+// change output.
+func signInputs(ctx context.Context, d *Device, tx *wire.MsgTx, inputs []*signingInput, change *wallet.Address) ([]*btcec.Signature, error) {
+	if d.wallet < 0 {
+		return nil, ERR_NO_WALLET_LOADED
+	}
+	if len(inputs) == 0 {
+		return nil, ERR_NO_INPUTS
+	}
+	if d.features != nil && d.features.Spv {
+		return nil, ERR_SPV_UNSUPPORTED
+	}
+
+	m := &models.SignTransactionExtended{}
+	data := &bytes.Buffer{}
+	preimages := make([][]byte, len(inputs))
+	for i, in := range inputs {
+		m.Handles = append(m.Handles, addressHandle(in.address))
+		preimage, err := signingPreimage(tx, in.index, in.address)
+		if err != nil {
+			return nil, err
+		}
+		preimages[i] = preimage
+		data.WriteByte(TX_DATA_UNSIGNED)
+		data.Write(preimage)
+	}
+	m.TransactionData = data.Bytes()
+	if change != nil {
+		m.ChangeAddress = addressHandle(change)
+	}
+
+	res := &models.TransactionSignatures{}
+	err := callExpect(ctx, d, hid.PREFIX_SIGN_TRANSACTION, m, hid.RESPONSE_TX_SIGNATURES, res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Signatures) != len(inputs) {
+		return nil, &SignatureCountError{Inputs: len(inputs), Signatures: len(res.Signatures)}
+	}
+
+	sigs := make([]*btcec.Signature, len(inputs))
+	for i, in := range inputs {
+		sig, err := checkSignature(in.address, preimages[i], res.Signatures[i].Signature)
+		if err != nil {
+			return nil, &InputSignatureError{Input: in.index, Err: err}
+		}
+		sigs[i] = sig
+	}
+	return sigs, nil
+}
+
+func addressHandle(a *wallet.Address) []byte {
+	return (&models.AddressHandleExtended{Root: 0, Chain: a.Chain, Index: a.ChainIndex}).Bytes()
+}
+
+// signingPreimage is what gets double hashed and signed for input i
+// with SIGHASH_ALL: the transaction with only that input's script set,
+// to the script of the address it spends from, followed by the hash
+// type
+func signingPreimage(tx *wire.MsgTx, i int, address *wallet.Address) ([]byte, error) {
+	prevScript, err := address.PkScript()
+	if err != nil {
+		return nil, err
+	}
+	tx = tx.Copy()
+	for j := range tx.TxIn {
+		tx.TxIn[j].SignatureScript = nil
+		tx.TxIn[j].Witness = nil
+	}
+	tx.TxIn[i].SignatureScript = prevScript
+
+	b := &bytes.Buffer{}
+	if err := tx.SerializeNoWitness(b); err != nil {
+		return nil, err
+	}
+	hashType := make([]byte, 4)
+	binary.LittleEndian.PutUint32(hashType, uint32(txscript.SigHashAll))
+	b.Write(hashType)
+	return b.Bytes(), nil
+}
+
+// checkSignature parses derSig and checks it signs preimage with the
+// key of address
+func checkSignature(address *wallet.Address, preimage, derSig []byte) (*btcec.Signature, error) {
+	sig, err := btcec.ParseDERSignature(derSig, btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	key, err := address.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	if !sig.Verify(doubleSha(preimage), key) {
+		return nil, ERR_INVALID_SIG
+	}
+	return sig, nil
+}
+
+// verifyInput runs the script engine over input i, so a spend the
+// network would reject is never handed back
+func verifyInput(tx *wire.MsgTx, i int, in *wallet.SpendInput) error {
+	prevScript, err := in.Address.PkScript()
+	if err != nil {
+		return err
+	}
+	engine, err := txscript.NewEngine(prevScript, tx, i, txscript.StandardVerifyFlags, nil, nil, int64(in.Output.Value))
+	if err != nil {
+		return err
+	}
+	return engine.Execute()
+}
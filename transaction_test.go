@@ -0,0 +1,59 @@
+package bitlox_test
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/golang/protobuf/proto"
+
+	models "bitlox/proto"
+
+	"bytes"
+	"testing"
+)
+
+// TestDecodeTransactionSignatures decodes a SignatureComplete reply
+// built by hand as bitlox.proto defines it: each signature is a
+// SignatureCompleteData message nested in field 1
+func TestDecodeTransactionSignatures(t *testing.T) {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ders := make([][]byte, 2)
+	reply := []byte{}
+	for i := range ders {
+		sig, err := key.Sign(bytes.Repeat([]byte{byte(i + 1)}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ders[i] = sig.Serialize()
+		// signature_data_complete = 1 inside signature_complete_data = 1
+		data := append([]byte{0x0a, byte(len(ders[i]))}, ders[i]...)
+		reply = append(reply, 0x0a, byte(len(data)))
+		reply = append(reply, data...)
+	}
+
+	res := &models.TransactionSignatures{}
+	if err := proto.Unmarshal(reply, res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Signatures) != len(ders) {
+		t.Fatalf("decoded %d signatures, expected %d", len(res.Signatures), len(ders))
+	}
+	for i, der := range ders {
+		if !bytes.Equal(res.Signatures[i].Signature, der) {
+			t.Fatalf("signature %d: got %x, expected %x", i, res.Signatures[i].Signature, der)
+		}
+		if _, err := btcec.ParseDERSignature(res.Signatures[i].Signature, btcec.S256()); err != nil {
+			t.Fatalf("signature %d: %s", i, err)
+		}
+	}
+
+	// and the simulator's reply encodes the same way
+	b, err := proto.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, reply) {
+		t.Fatalf("marshalled %x, expected %x", b, reply)
+	}
+}
@@ -50,6 +50,18 @@ func (a *Address) ECPubKey() (*btcec.PublicKey, error) {
 	return a.key.ECPubKey()
 }
 
+// Used reports whether LoadBalance found the address has received
+// anything
+func (a *Address) Used() bool {
+	if len(a.Unspent) > 0 {
+		return true
+	}
+	if a.BalanceInfo == nil {
+		return false
+	}
+	return a.BalanceInfo.Received > 0 || a.BalanceInfo.UnconfirmedReceived > 0
+}
+
 func (a *Address) Balance() btcinfo.Satoshi {
 	if a.BalanceInfo == nil {
 		return 0
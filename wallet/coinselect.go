@@ -0,0 +1,224 @@
+package wallet
+
+import (
+	"bitlox/btcinfo"
+
+	"math/rand"
+	"sort"
+)
+
+// give up on branch and bound after this many steps
+const BNB_MAX_TRIES = 100000
+
+// Strategy picks which of the unspent outputs pay amount. opts may be
+// nil. It returns ERR_INSUFFICIENT_FUNDS if it finds no selection.
+type Strategy func(utxos []*SpendInput, amount btcinfo.Satoshi, opts *SelectOptions) (*Selection, error)
+
+// Strategies by the name the CLI knows them by
+var Strategies = map[string]Strategy{
+	"auto":          AutoSelect,
+	"bnb":           BranchAndBound,
+	"largest-first": LargestFirst,
+	"random":        SingleRandomDraw,
+}
+
+// SelectOptions tune coin selection. A nil *SelectOptions is the zero
+// value.
+type SelectOptions struct {
+	// satoshis per byte. Unlike the other fields it has no default,
+	// zero pays no fee.
+	FeeRate btcinfo.Satoshi
+	// amounts below this are refused, DUST_LIMIT by default
+	DustLimit btcinfo.Satoshi
+	// change below this goes to the fee instead, DustLimit by default
+	MinChange btcinfo.Satoshi
+	// used by SingleRandomDraw, the math/rand default source if nil
+	Rand *rand.Rand
+}
+
+// orDefault is opts, or the zero value if it is nil
+func orDefault(opts *SelectOptions) *SelectOptions {
+	if opts == nil {
+		return &SelectOptions{}
+	}
+	return opts
+}
+
+func (o *SelectOptions) dustLimit() btcinfo.Satoshi {
+	if o.DustLimit > 0 {
+		return o.DustLimit
+	}
+	return DUST_LIMIT
+}
+
+func (o *SelectOptions) minChange() btcinfo.Satoshi {
+	if o.MinChange > 0 {
+		return o.MinChange
+	}
+	return o.dustLimit()
+}
+
+func (o *SelectOptions) shuffle(n int, swap func(i, j int)) {
+	if o.Rand != nil {
+		o.Rand.Shuffle(n, swap)
+		return
+	}
+	rand.Shuffle(n, swap)
+}
+
+// Selection is the outcome of coin selection: the inputs to spend, the
+// fee paid and the change left over, zero for no change output
+type Selection struct {
+	Inputs []*SpendInput
+	Total  btcinfo.Satoshi
+	Fee    btcinfo.Satoshi
+	Change btcinfo.Satoshi
+}
+
+// SelectCoins checks amount against the dust limit and runs strategy
+// over utxos
+func SelectCoins(utxos []*SpendInput, amount btcinfo.Satoshi, strategy Strategy, opts *SelectOptions) (*Selection, error) {
+	opts = orDefault(opts)
+	if amount < opts.dustLimit() {
+		return nil, ERR_DUST_AMOUNT
+	}
+	return strategy(utxos, amount, opts)
+}
+
+// AutoSelect tries for a selection without change with BranchAndBound,
+// and falls back to LargestFirst
+func AutoSelect(utxos []*SpendInput, amount btcinfo.Satoshi, opts *SelectOptions) (*Selection, error) {
+	opts = orDefault(opts)
+	s, err := BranchAndBound(utxos, amount, opts)
+	if err == nil {
+		return s, nil
+	}
+	return LargestFirst(utxos, amount, opts)
+}
+
+// LargestFirst spends the biggest outputs first, which keeps the number
+// of inputs and so the fee low
+func LargestFirst(utxos []*SpendInput, amount btcinfo.Satoshi, opts *SelectOptions) (*Selection, error) {
+	opts = orDefault(opts)
+	sorted := append([]*SpendInput{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value > sorted[j].Output.Value })
+	return accumulate(sorted, amount, 0, opts)
+}
+
+// SingleRandomDraw spends outputs in random order until there is enough
+// for a change output of at least MinChange, which avoids always
+// revealing the same outputs together
+func SingleRandomDraw(utxos []*SpendInput, amount btcinfo.Satoshi, opts *SelectOptions) (*Selection, error) {
+	opts = orDefault(opts)
+	shuffled := append([]*SpendInput{}, utxos...)
+	opts.shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return accumulate(shuffled, amount, opts.minChange(), opts)
+}
+
+// accumulate takes utxos in order until they pay amount, the fee with a
+// change output and extra on top
+func accumulate(utxos []*SpendInput, amount, extra btcinfo.Satoshi, opts *SelectOptions) (*Selection, error) {
+	inputs := make([]*SpendInput, 0)
+	total := btcinfo.Satoshi(0)
+	for _, in := range utxos {
+		if effectiveValue(in, opts) <= 0 {
+			continue
+		}
+		inputs = append(inputs, in)
+		total += in.Output.Value
+		if total >= amount+EstimateFee(len(inputs), 2, opts.FeeRate)+extra {
+			break
+		}
+	}
+	return finish(inputs, total, amount, opts)
+}
+
+// finish works out the fee and change for inputs. Change smaller than
+// MinChange is left to the fee.
+func finish(inputs []*SpendInput, total, amount btcinfo.Satoshi, opts *SelectOptions) (*Selection, error) {
+	withChange := EstimateFee(len(inputs), 2, opts.FeeRate)
+	if total >= amount+withChange+opts.minChange() {
+		return &Selection{Inputs: inputs, Total: total, Fee: withChange, Change: total - amount - withChange}, nil
+	}
+	if total >= amount+EstimateFee(len(inputs), 1, opts.FeeRate) {
+		return &Selection{Inputs: inputs, Total: total, Fee: total - amount}, nil
+	}
+	return nil, ERR_INSUFFICIENT_FUNDS
+}
+
+// effectiveValue is what an output adds once the fee for spending it is
+// taken off
+func effectiveValue(in *SpendInput, opts *SelectOptions) btcinfo.Satoshi {
+	return in.Output.Value - INPUT_SIZE*opts.FeeRate
+}
+
+// BranchAndBound searches for a set of outputs that pays amount and its
+// fee with no change output, wasting less than a change output would
+// cost to create and later spend. It gives ERR_INSUFFICIENT_FUNDS if
+// there is no such set.
+func BranchAndBound(utxos []*SpendInput, amount btcinfo.Satoshi, opts *SelectOptions) (*Selection, error) {
+	opts = orDefault(opts)
+	pool := make([]*SpendInput, 0, len(utxos))
+	available := btcinfo.Satoshi(0)
+	for _, in := range utxos {
+		if v := effectiveValue(in, opts); v > 0 {
+			pool = append(pool, in)
+			available += v
+		}
+	}
+	sort.SliceStable(pool, func(i, j int) bool { return effectiveValue(pool[i], opts) > effectiveValue(pool[j], opts) })
+
+	target := amount + EstimateFee(0, 1, opts.FeeRate)
+	costOfChange := (OUTPUT_SIZE + INPUT_SIZE) * opts.FeeRate
+	if available < target {
+		return nil, ERR_INSUFFICIENT_FUNDS
+	}
+
+	// depth first over including then excluding each output, largest
+	// first, keeping the selection that overshoots target the least
+	var best []int
+	bestWaste := btcinfo.Satoshi(-1)
+	selection := make([]int, 0)
+	value := btcinfo.Satoshi(0)
+	for tries, i := 0, 0; tries < BNB_MAX_TRIES; tries, i = tries+1, i+1 {
+		backtrack := false
+		if value+available < target || value > target+costOfChange {
+			backtrack = true
+		} else if value >= target {
+			if waste := value - target; bestWaste < 0 || waste < bestWaste {
+				best = append([]int{}, selection...)
+				bestWaste = waste
+			}
+			backtrack = true
+		}
+
+		if !backtrack {
+			available -= effectiveValue(pool[i], opts)
+			selection = append(selection, i)
+			value += effectiveValue(pool[i], opts)
+			continue
+		}
+		if len(selection) == 0 {
+			break
+		}
+		// give back the outputs skipped since the last one included,
+		// then exclude that one and carry on after it
+		last := selection[len(selection)-1]
+		for i--; i > last; i-- {
+			available += effectiveValue(pool[i], opts)
+		}
+		value -= effectiveValue(pool[last], opts)
+		selection = selection[:len(selection)-1]
+	}
+
+	if best == nil {
+		return nil, ERR_INSUFFICIENT_FUNDS
+	}
+	inputs := make([]*SpendInput, 0)
+	total := btcinfo.Satoshi(0)
+	for _, i := range best {
+		inputs = append(inputs, pool[i])
+		total += pool[i].Output.Value
+	}
+	return &Selection{Inputs: inputs, Total: total, Fee: total - amount}, nil
+}
@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"bitlox/btcinfo"
+
+	"math/rand"
+	"testing"
+)
+
+func utxos(values ...btcinfo.Satoshi) []*SpendInput {
+	inputs := make([]*SpendInput, len(values))
+	for i, v := range values {
+		inputs[i] = &SpendInput{Output: &btcinfo.Output{Value: v, Number: i}}
+	}
+	return inputs
+}
+
+func selected(s *Selection) map[btcinfo.Satoshi]bool {
+	values := make(map[btcinfo.Satoshi]bool)
+	for _, in := range s.Inputs {
+		values[in.Output.Value] = true
+	}
+	return values
+}
+
+func TestBranchAndBoundExactMatch(t *testing.T) {
+	opts := &SelectOptions{FeeRate: 1}
+	amount := btcinfo.Satoshi(100000)
+	// after paying for their inputs these give exactly amount and the
+	// fee of a transaction with one output
+	a := 60000 + INPUT_SIZE*opts.FeeRate
+	b := amount + EstimateFee(0, 1, opts.FeeRate) - 60000 + INPUT_SIZE*opts.FeeRate
+
+	s, err := BranchAndBound(utxos(500000, a, 1000, b), amount, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := selected(s); len(values) != 2 || !values[a] || !values[b] {
+		t.Fatalf("selected %v, expected %v and %v", values, a, b)
+	}
+	if s.Change != 0 {
+		t.Errorf("change %v, expected none", s.Change)
+	}
+	if s.Fee != EstimateFee(2, 1, opts.FeeRate) {
+		t.Errorf("fee %v, expected %v", s.Fee, EstimateFee(2, 1, opts.FeeRate))
+	}
+}
+
+func TestBranchAndBoundNoMatch(t *testing.T) {
+	// every combination leaves more than a change output costs
+	_, err := BranchAndBound(utxos(500000, 900000), 100000, &SelectOptions{FeeRate: 1})
+	if err != ERR_INSUFFICIENT_FUNDS {
+		t.Fatalf("expected ERR_INSUFFICIENT_FUNDS, got %v", err)
+	}
+}
+
+func TestChange(t *testing.T) {
+	opts := &SelectOptions{FeeRate: 1}
+	amount := btcinfo.Satoshi(100000)
+
+	s, err := LargestFirst(utxos(1000000, 5000), amount, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := EstimateFee(1, 2, opts.FeeRate)
+	if len(s.Inputs) != 1 || s.Fee != fee || s.Change != 1000000-amount-fee {
+		t.Errorf("got %d inputs, fee %v, change %v", len(s.Inputs), s.Fee, s.Change)
+	}
+
+	// what is left over is too small for a change output, so it goes
+	// to the fee
+	total := amount + EstimateFee(1, 1, opts.FeeRate) + 100
+	s, err = LargestFirst(utxos(total), amount, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Change != 0 || s.Fee != total-amount {
+		t.Errorf("got fee %v, change %v, expected fee %v and no change", s.Fee, s.Change, total-amount)
+	}
+
+	// SingleRandomDraw keeps going until the change reaches MinChange
+	opts = &SelectOptions{FeeRate: 1, MinChange: 20000, Rand: rand.New(rand.NewSource(1))}
+	s, err = SingleRandomDraw(utxos(50000, 50000, 50000, 50000), amount, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Change < opts.MinChange {
+		t.Errorf("change %v below MinChange %v", s.Change, opts.MinChange)
+	}
+}
+
+func TestInsufficientFunds(t *testing.T) {
+	opts := &SelectOptions{FeeRate: 10}
+	// enough for the amount but not for the fee as well
+	inputs := utxos(60000, 40000)
+	for name, strategy := range Strategies {
+		_, err := SelectCoins(inputs, 100000, strategy, opts)
+		if err != ERR_INSUFFICIENT_FUNDS {
+			t.Errorf("%s: expected ERR_INSUFFICIENT_FUNDS, got %v", name, err)
+		}
+	}
+}
+
+func TestDust(t *testing.T) {
+	inputs := utxos(100000)
+	if _, err := SelectCoins(inputs, DUST_LIMIT-1, LargestFirst, nil); err != ERR_DUST_AMOUNT {
+		t.Errorf("expected ERR_DUST_AMOUNT, got %v", err)
+	}
+	if _, err := SelectCoins(inputs, 1000, LargestFirst, &SelectOptions{DustLimit: 2000}); err != ERR_DUST_AMOUNT {
+		t.Errorf("expected ERR_DUST_AMOUNT with a raised limit, got %v", err)
+	}
+
+	// outputs worth less than the fee to spend them are never picked,
+	// whichever order they are drawn in
+	dust := INPUT_SIZE * btcinfo.Satoshi(10)
+	for seed := int64(0); seed < 10; seed++ {
+		opts := &SelectOptions{FeeRate: 10, Rand: rand.New(rand.NewSource(seed))}
+		s, err := SingleRandomDraw(utxos(dust, 100000, dust), 50000, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selected(s)[dust] {
+			t.Fatalf("seed %d: dust output selected", seed)
+		}
+	}
+}
+
+func TestNilOptions(t *testing.T) {
+	for name, strategy := range Strategies {
+		if _, err := strategy(utxos(100000), 50000, nil); err != nil && err != ERR_INSUFFICIENT_FUNDS {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/wire"
+	bip32 "github.com/btcsuite/btcutil/hdkeychain"
+
+	"bitlox/btcinfo"
+	"bitlox/psbt"
+
+	"bytes"
+	"errors"
+)
+
+// the device's address handles all hang off the first hardened child
+// of the wallet's master key, which is where the scanned xpub is
+const ROOT_INDEX = bip32.HardenedKeyStart
+
+var ERR_PREVIOUS_TX_MISMATCH = errors.New("Previous transaction does not have the output being spent")
+
+// Fingerprint is the fingerprint of the wallet's master key, the parent
+// of the scanned xpub, as used in PSBT derivation paths
+func (w *Wallet) Fingerprint() (uint32, error) {
+	k, err := w.MasterKey()
+	if err != nil {
+		return 0, err
+	}
+	return k.ParentFingerprint(), nil
+}
+
+// Path is the BIP32 path of the address from the wallet's master key:
+// the root, chain and index of its device address handle
+func (a *Address) Path() []uint32 {
+	return []uint32{ROOT_INDEX, a.Chain, a.ChainIndex}
+}
+
+// AddressAt is the address at index on chain
+func (w *Wallet) AddressAt(chain, chainIndex uint32) (*Address, error) {
+	return w.generateAddress(chain, chainIndex)
+}
+
+// Derivation describes the key of the address for a PSBT
+func (w *Wallet) Derivation(a *Address) (*psbt.Bip32Derivation, error) {
+	fingerprint, err := w.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	key, err := a.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return &psbt.Bip32Derivation{PubKey: key.SerializeCompressed(), Fingerprint: fingerprint, Path: a.Path()}, nil
+}
+
+// FindAddress returns the wallet address one of ds derives, or nil if
+// none of them are from this wallet
+func (w *Wallet) FindAddress(ds []*psbt.Bip32Derivation) (*Address, error) {
+	fingerprint, err := w.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range ds {
+		if d.Fingerprint != fingerprint || len(d.Path) != 3 || d.Path[0] != ROOT_INDEX {
+			continue
+		}
+		if d.Path[1] != CHAIN_INDEX_RECEIVE && d.Path[1] != CHAIN_INDEX_CHANGE {
+			continue
+		}
+		a, err := w.AddressAt(d.Path[1], d.Path[2])
+		if err != nil {
+			return nil, err
+		}
+		key, err := a.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		// a matching fingerprint can be chance, the key cannot
+		if bytes.Equal(key.SerializeCompressed(), d.PubKey) {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// NewPSBT exports the spend as an unsigned PSBT, with the derivation of
+// every input and of the change output. The inputs are not segwit, so
+// BIP174 wants the whole transaction each one spends from; these come
+// from the btcinfo backend, and a spend whose previous transactions it
+// cannot give is not exported.
+func (w *Wallet) NewPSBT(s *Spend) (*psbt.Packet, error) {
+	p, err := psbt.New(s.Tx.Copy())
+	if err != nil {
+		return nil, err
+	}
+	for i, in := range s.Inputs {
+		prev, err := previousTx(s.Tx.TxIn[i].PreviousOutPoint, in)
+		if err != nil {
+			return nil, err
+		}
+		d, err := w.Derivation(in.Address)
+		if err != nil {
+			return nil, err
+		}
+		p.Inputs[i].NonWitnessUtxo = prev
+		p.Inputs[i].Bip32Derivation = []*psbt.Bip32Derivation{d}
+	}
+	if s.Change != nil {
+		d, err := w.Derivation(s.Change)
+		if err != nil {
+			return nil, err
+		}
+		// build puts change after the payment
+		p.Outputs[len(p.Outputs)-1].Bip32Derivation = []*psbt.Bip32Derivation{d}
+	}
+	return p, nil
+}
+
+// previousTx fetches the transaction the input spends from and checks
+// that the output spent is the one coin selection saw
+func previousTx(outpoint wire.OutPoint, in *SpendInput) (*wire.MsgTx, error) {
+	prev, err := btcinfo.GetTransaction(in.Output.HashStr)
+	if err != nil {
+		return nil, err
+	}
+	if prev.TxHash() != outpoint.Hash || int(outpoint.Index) >= len(prev.TxOut) {
+		return nil, ERR_PREVIOUS_TX_MISMATCH
+	}
+	script, err := in.Address.PkScript()
+	if err != nil {
+		return nil, err
+	}
+	out := prev.TxOut[outpoint.Index]
+	if out.Value != int64(in.Output.Value) || !bytes.Equal(out.PkScript, script) {
+		return nil, ERR_PREVIOUS_TX_MISMATCH
+	}
+	return prev, nil
+}
@@ -0,0 +1,88 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/wire"
+
+	"bitlox/btcinfo"
+
+	"encoding/hex"
+	"testing"
+)
+
+// fund has mock pay value to a in a new transaction
+func fund(t *testing.T, mock *btcinfo.Mock, a *Address, value btcinfo.Satoshi) *wire.MsgTx {
+	script, err := a.PkScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(len(mock.Transactions))}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(value), script))
+	if err := mock.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mock.Addresses[a.String()] = &btcinfo.Address{Received: value, Balance: value}
+	mock.Unspent[a.String()] = []*btcinfo.Output{{
+		HashStr:   tx.TxHash().String(),
+		Value:     value,
+		ScriptStr: hex.EncodeToString(script),
+		Number:    0,
+	}}
+	return tx
+}
+
+func TestNewPSBTPreviousTransactions(t *testing.T) {
+	w := testWallet(t)
+	a, err := w.ReceiveAddress(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := btcinfo.NewMock()
+	prev := fund(t, mock, a, 100000)
+	btcinfo.SetBackend(mock)
+	defer btcinfo.SetBackend(&btcinfo.Toshi{})
+
+	to, err := w.ReceiveAddress(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = testWallet(t)
+	w.LoadBalance()
+	spend, err := w.NewSpend(to.String(), 50000, LargestFirst, &SelectOptions{FeeRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := w.NewPSBT(spend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := p.Inputs[0]
+	if in.NonWitnessUtxo == nil || in.NonWitnessUtxo.TxHash() != prev.TxHash() {
+		t.Fatalf("input lacks the previous transaction")
+	}
+	if in.WitnessUtxo != nil {
+		t.Errorf("P2PKH input has a witness utxo")
+	}
+	if fee, err := p.Fee(); err != nil || fee != int64(spend.Fee) {
+		t.Errorf("PSBT fee %d (%v), spend fee %v", fee, err, spend.Fee)
+	}
+
+	// the backend disagreeing with the unspent output is refused
+	mock.Unspent[a.String()][0].Value = 90000
+	w = testWallet(t)
+	w.LoadBalance()
+	spend, err = w.NewSpend(to.String(), 50000, LargestFirst, &SelectOptions{FeeRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.NewPSBT(spend); err != ERR_PREVIOUS_TX_MISMATCH {
+		t.Errorf("expected ERR_PREVIOUS_TX_MISMATCH, got %v", err)
+	}
+
+	// as is a previous transaction the backend cannot give
+	delete(mock.Transactions, prev.TxHash().String())
+	if _, err := w.NewPSBT(spend); err != btcinfo.ERR_UNKNOWN_TRANSACTION {
+		t.Errorf("expected ERR_UNKNOWN_TRANSACTION, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"bitlox/btcinfo"
+
+	"errors"
+)
+
+// sizes in bytes used to estimate the fee of a spend from P2PKH
+// addresses
+const TX_OVERHEAD_SIZE = 10
+const INPUT_SIZE = 148
+const OUTPUT_SIZE = 34
+
+// outputs smaller than this are not relayed, change below it goes to
+// the fee instead unless SelectOptions say otherwise
+const DUST_LIMIT btcinfo.Satoshi = 546
+
+var ERR_INSUFFICIENT_FUNDS = errors.New("Insufficient funds")
+var ERR_DUST_AMOUNT = errors.New("Amount is below the dust limit")
+
+// SpendInput is an unspent output of the wallet and the address it
+// pays to
+type SpendInput struct {
+	Address *Address
+	Output  *btcinfo.Output
+}
+
+// Spend is an unsigned transaction from the wallet, with what is
+// needed to have the device sign it
+type Spend struct {
+	Tx *wire.MsgTx
+	// Inputs are in the same order as Tx.TxIn
+	Inputs []*SpendInput
+	Amount btcinfo.Satoshi
+	Fee    btcinfo.Satoshi
+	// Change is nil when the spend has no change output
+	Change       *Address
+	ChangeAmount btcinfo.Satoshi
+}
+
+// PkScript is the P2PKH script paying to the address
+func (a *Address) PkScript() ([]byte, error) {
+	hash, err := a.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return txscript.PayToAddrScript(hash)
+}
+
+// Unspent lists the unspent outputs found by LoadBalance on both
+// chains
+func (w *Wallet) Unspent() []*SpendInput {
+	inputs := make([]*SpendInput, 0)
+	for _, chain := range []uint32{CHAIN_INDEX_RECEIVE, CHAIN_INDEX_CHANGE} {
+		for _, addr := range w.Addresses(chain) {
+			for _, output := range addr.Unspent {
+				inputs = append(inputs, &SpendInput{Address: addr, Output: output})
+			}
+		}
+	}
+	return inputs
+}
+
+// EstimateFee is the fee for a transaction of the given shape at
+// feeRate satoshis per byte
+func EstimateFee(inputs, outputs int, feeRate btcinfo.Satoshi) btcinfo.Satoshi {
+	size := TX_OVERHEAD_SIZE + inputs*INPUT_SIZE + outputs*OUTPUT_SIZE
+	return btcinfo.Satoshi(size) * feeRate
+}
+
+// NewSpend builds an unsigned transaction paying amount to the address
+// to, with inputs chosen from Unspent by strategy. Any change goes to
+// ChangeAddress, which the device is told about so it can leave the
+// change output off its confirmation screen.
+func (w *Wallet) NewSpend(to string, amount btcinfo.Satoshi, strategy Strategy, opts *SelectOptions) (*Spend, error) {
+	toAddr, err := btcutil.DecodeAddress(to, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+	toScript, err := txscript.PayToAddrScript(toAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	selection, err := SelectCoins(w.Unspent(), amount, strategy, opts)
+	if err != nil {
+		return nil, err
+	}
+	spend := &Spend{
+		Tx:     wire.NewMsgTx(wire.TxVersion),
+		Inputs: selection.Inputs,
+		Amount: amount,
+		Fee:    selection.Fee,
+	}
+	if selection.Change > 0 {
+		spend.Change, err = w.ChangeAddress()
+		if err != nil {
+			return nil, err
+		}
+		spend.ChangeAmount = selection.Change
+	}
+
+	if err := spend.build(toScript); err != nil {
+		return nil, err
+	}
+	return spend, nil
+}
+
+// build fills in Tx from the inputs and amounts chosen
+func (s *Spend) build(toScript []byte) error {
+	for _, in := range s.Inputs {
+		hash, err := chainhash.NewHashFromStr(in.Output.HashStr)
+		if err != nil {
+			return err
+		}
+		s.Tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, uint32(in.Output.Number)), nil, nil))
+	}
+	s.Tx.AddTxOut(wire.NewTxOut(int64(s.Amount), toScript))
+	if s.Change != nil {
+		changeScript, err := s.Change.PkScript()
+		if err != nil {
+			return err
+		}
+		s.Tx.AddTxOut(wire.NewTxOut(int64(s.ChangeAmount), changeScript))
+	}
+	return nil
+}
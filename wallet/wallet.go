@@ -122,6 +122,31 @@ func (w *Wallet) ReceiveAddress(chainIndex uint32) (*Address, error) {
 	return w.generateAddress(CHAIN_INDEX_RECEIVE, chainIndex)
 }
 
+// ChangeAddress is the first address on the change chain that has not
+// been used. Addresses LoadBalance has not looked up yet are looked up
+// on the way, and indexes without a valid key are skipped.
+func (w *Wallet) ChangeAddress() (*Address, error) {
+	for chainIndex := uint32(0); ; chainIndex++ {
+		address, err := w.generateAddress(CHAIN_INDEX_CHANGE, chainIndex)
+		if err == bip32.ErrInvalidChild {
+			logger.Debug("Skipping invalid change address", chainIndex)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if address.BalanceInfo == nil {
+			address.BalanceInfo, err = btcinfo.GetAddress(address.String())
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !address.Used() {
+			return address, nil
+		}
+	}
+}
+
 func (w *Wallet) LoadBalance() {
 	w.loadAllAddresses()
 }
@@ -0,0 +1,51 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	bip32 "github.com/btcsuite/btcutil/hdkeychain"
+
+	"bitlox/btcinfo"
+
+	"testing"
+)
+
+// testWallet is a wallet on the first hardened child of a fixed seed,
+// as the device scans it
+func testWallet(t *testing.T) *Wallet {
+	master, err := bip32.NewMaster(make([]byte, 32), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := master.Child(ROOT_INDEX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err = k.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return WalletFromXpub([]byte(k.String()))
+}
+
+func TestChangeAddressLooksUpUnscanned(t *testing.T) {
+	w := testWallet(t)
+	used, err := w.AddressAt(CHAIN_INDEX_CHANGE, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := btcinfo.NewMock()
+	mock.Addresses[used.String()] = &btcinfo.Address{Received: 1000}
+	btcinfo.SetBackend(mock)
+	defer btcinfo.SetBackend(&btcinfo.Toshi{})
+
+	// no LoadBalance, so ChangeAddress has to look index 0 up itself
+	w = testWallet(t)
+	change, err := w.ChangeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Chain != CHAIN_INDEX_CHANGE || change.ChainIndex != 1 {
+		t.Errorf("got chain %d index %d, expected change index 1", change.Chain, change.ChainIndex)
+	}
+}
@@ -0,0 +1,142 @@
+package bitlox
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"bitlox/hid"
+	models "bitlox/proto"
+
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// names are null terminated UTF-8 of at most this many bytes,
+// terminator included
+const MAX_NAME_LENGTH = 40
+
+var ERR_NAME_TOO_LONG = errors.New(fmt.Sprintf("Name exceeds %d bytes", MAX_NAME_LENGTH-1))
+var ERR_NAME_INVALID = errors.New("Name is not valid UTF-8")
+var ERR_NO_FREE_WALLET = errors.New("No free wallet number")
+var ERR_NO_WALLET_LOADED = errors.New("No wallet loaded")
+
+// encodeName turns a wallet or device name into the null terminated
+// form the device stores
+func encodeName(name string) ([]byte, error) {
+	if !utf8.ValidString(name) {
+		return nil, ERR_NAME_INVALID
+	}
+	if len(name)+1 > MAX_NAME_LENGTH {
+		return nil, ERR_NAME_TOO_LONG
+	}
+	return append([]byte(name), 0x00), nil
+}
+
+// NewWallet creates a wallet with the given number and name. An empty
+// password makes an unencrypted wallet. The device asks for a button
+// press before creating it.
+func NewWallet(ctx context.Context, d *Device, number uint32, name string, password []byte, hidden bool) error {
+	m, err := newWalletMessage(number, name, password, hidden)
+	if err != nil {
+		return err
+	}
+
+	return callSuccess(ctx, d, hid.PREFIX_NEW_WALLET, m)
+}
+
+// RestoreWallet creates a wallet the same way NewWallet does, but from
+// an existing seed instead of one the device generates. Use
+// SeedFromMnemonic to turn a BIP39 mnemonic into a seed.
+func RestoreWallet(ctx context.Context, d *Device, number uint32, name string, password []byte, hidden bool, seed []byte) error {
+	if len(seed) != SEED_LENGTH {
+		return ERR_SEED_LENGTH
+	}
+
+	nw, err := newWalletMessage(number, name, password, hidden)
+	if err != nil {
+		return err
+	}
+
+	m := &models.RestoreWallet{
+		NewWallet: nw,
+		Seed:      seed,
+	}
+
+	return callSuccess(ctx, d, hid.PREFIX_RESTORE_WALLET, m)
+}
+
+// DeleteWallet removes a wallet from the device. The device asks for a
+// button press first. The wallet does not have to be loaded.
+func DeleteWallet(ctx context.Context, d *Device, number byte) error {
+	err := callSuccess(ctx, d, walletCommand(hid.PREFIX_DELETE_WALLET, number), nil)
+	if err == nil && d.wallet == int(number) {
+		d.wallet = -1
+	}
+	return err
+}
+
+// walletCommand is the LoadWallet or DeleteWallet command for wallet
+// number. prefix is sized for a one byte number, but the number is a
+// varint, which takes two bytes from 128 up.
+func walletCommand(prefix []byte, number byte) []byte {
+	// the command, then field 1 as a varint
+	field := append([]byte{0x08}, proto.EncodeVarint(uint64(number))...)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(field)))
+	cmd := append(append([]byte{}, prefix[0:2]...), size...)
+	return append(cmd, field...)
+}
+
+// RenameWallet changes the name of the loaded wallet. The device asks
+// for a button press first.
+func RenameWallet(ctx context.Context, d *Device, name string) error {
+	if d.wallet < 0 {
+		return ERR_NO_WALLET_LOADED
+	}
+
+	nameBytes, err := encodeName(name)
+	if err != nil {
+		return err
+	}
+
+	return callSuccess(ctx, d, hid.PREFIX_RENAME_WALLET, &models.ChangeWalletName{Name: nameBytes})
+}
+
+func newWalletMessage(number uint32, name string, password []byte, hidden bool) (*models.NewWallet, error) {
+	nameBytes, err := encodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &models.NewWallet{
+		Number:   number,
+		Name:     nameBytes,
+		IsHidden: hidden,
+	}
+	if len(password) > 0 {
+		m.Password = password
+	}
+	return m, nil
+}
+
+// FreeWalletNumber returns the lowest wallet number not listed by the
+// device. Hidden wallets are never listed, so creating a wallet there
+// can still fail.
+func FreeWalletNumber(ctx context.Context, d *Device) (uint32, error) {
+	wallets, err := GetWallets(ctx, d)
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[uint32]bool)
+	for _, w := range wallets {
+		used[uint32(w.Number)] = true
+	}
+	for number := uint32(0); number < 0xff; number++ {
+		if !used[number] {
+			return number, nil
+		}
+	}
+	return 0, ERR_NO_FREE_WALLET
+}
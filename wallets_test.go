@@ -0,0 +1,40 @@
+package bitlox_test
+
+import (
+	"bitlox"
+	"bitlox/simulator"
+
+	"context"
+	"testing"
+)
+
+// TestWalletNumberVarint loads and deletes a wallet numbered above 127,
+// which needs two bytes as a varint
+func TestWalletNumberVarint(t *testing.T) {
+	ctx := context.Background()
+	sim, err := simulator.New(simulator.TestSeed, "Test wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := bitlox.Open(ctx, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, number := range []byte{0x7f, 0x80, 0xff} {
+		if err := bitlox.NewWallet(ctx, d, uint32(number), "high", nil, false); err != nil {
+			t.Fatalf("wallet %d: %s", number, err)
+		}
+		if err := bitlox.LoadWallet(ctx, d, number); err != nil {
+			t.Fatalf("loading wallet %d: %s", number, err)
+		}
+		if err := bitlox.DeleteWallet(ctx, d, number); err != nil {
+			t.Fatalf("deleting wallet %d: %s", number, err)
+		}
+	}
+	// the one byte encoding still reaches wallet 0
+	if err := bitlox.LoadWallet(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+}
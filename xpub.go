@@ -0,0 +1,79 @@
+package bitlox
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil/base58"
+	bip32 "github.com/btcsuite/btcutil/hdkeychain"
+
+	"bitlox/hid"
+	models "bitlox/proto"
+
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ERR_INVALID_CHAIN_CODE = errors.New("Master public key has an invalid chain code")
+
+// MasterKeyMismatchError is returned by GetMasterPublicKey when the key
+// the device reports is not the one ScanWallet gives for the wallet
+type MasterKeyMismatchError struct {
+	Scanned  string
+	Reported string
+}
+
+func (e *MasterKeyMismatchError) Error() string {
+	return fmt.Sprintf("Device master public key %s does not match scanned %s", e.Reported, e.Scanned)
+}
+
+// GetMasterPublicKey fetches the public key and chain code of the
+// loaded wallet and returns them as an extended key. The same key is
+// fetched with ScanWallet and the two compared, giving a
+// *MasterKeyMismatchError if the firmware contradicts itself.
+func GetMasterPublicKey(ctx context.Context, d *Device) (*bip32.ExtendedKey, error) {
+	if d.wallet < 0 {
+		return nil, ERR_NO_WALLET_LOADED
+	}
+
+	mpk := &models.MasterPublicKey{}
+
+	err := callExpect(ctx, d, hid.COMMAND_GET_MASTER_PUBLIC_KEY, nil, hid.RESPONSE_MASTER_PUBLIC_KEY, mpk)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mpk.ChainCode) != 32 {
+		return nil, ERR_INVALID_CHAIN_CODE
+	}
+	pub, err := btcec.ParsePubKey(mpk.PublicKey, btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+
+	scanned, err := ScanWallet(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bip32.NewKeyFromString(string(scanned)); err != nil {
+		return nil, err
+	}
+
+	// the device only sends the key itself, the network and where it
+	// sits in the tree come from the scanned key
+	raw := base58.Decode(string(scanned))
+	key := bip32.NewExtendedKey(
+		raw[0:4],
+		pub.SerializeCompressed(),
+		mpk.ChainCode,
+		raw[5:9],
+		raw[4],
+		uint32(raw[9])<<24|uint32(raw[10])<<16|uint32(raw[11])<<8|uint32(raw[12]),
+		false,
+	)
+
+	if !bytes.Equal([]byte(key.String()), scanned) {
+		return nil, &MasterKeyMismatchError{Scanned: string(scanned), Reported: key.String()}
+	}
+	return key, nil
+}
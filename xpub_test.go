@@ -0,0 +1,48 @@
+package bitlox_test
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	bip32 "github.com/btcsuite/btcutil/hdkeychain"
+
+	"bitlox"
+	"bitlox/simulator"
+
+	"context"
+	"testing"
+)
+
+// TestGetMasterPublicKey checks the key the simulator reports against
+// the one derived from its seed, m/0'/0' for the first wallet
+func TestGetMasterPublicKey(t *testing.T) {
+	_, d := openSimulator(t, "Test wallet")
+	ctx := context.Background()
+
+	if _, err := bitlox.GetMasterPublicKey(ctx, d); err != bitlox.ERR_NO_WALLET_LOADED {
+		t.Fatalf("expected ERR_NO_WALLET_LOADED, got %v", err)
+	}
+	if err := bitlox.LoadWallet(ctx, d, 0); err != nil {
+		t.Fatal(err)
+	}
+	key, err := bitlox.GetMasterPublicKey(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := bip32.NewMaster(simulator.TestSeed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		expected, err = expected.Child(bip32.HardenedKeyStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err = expected.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.String() != expected.String() {
+		t.Fatalf("got %s, expected %s", key, expected)
+	}
+}